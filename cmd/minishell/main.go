@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -10,13 +11,20 @@ import (
 	"os/exec"
 	"os/signal"
 	"os/user"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"syscall"
 
+	"github.com/aliskhannn/minishell/internal/lineedit"
 	"github.com/aliskhannn/minishell/internal/shell"
+	"github.com/aliskhannn/minishell/internal/term"
 )
 
 func main() {
+	norc := flag.Bool("norc", false, "do not read the startup file (~/.minishellrc or $MINISHELL_RC)")
+	flag.Parse()
+
 	// Ignore the standard Ctrl+C so that the shell does not terminate.
 	signal.Ignore(syscall.SIGINT)
 
@@ -24,7 +32,11 @@ func main() {
 	signal.Notify(sigCh, syscall.SIGINT)
 
 	sh := shell.New()
-	reader := bufio.NewReader(os.Stdin)
+	interactive := term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+	if interactive {
+		sh.SetInteractive()
+	}
+	readLine := lineReader(sh, interactive)
 
 	u, err := user.Current()
 	if err != nil {
@@ -36,28 +48,68 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Load the user's configuration before the first prompt. Like bash
+	// with ~/.bashrc, only interactive sessions read it: scripts piped to
+	// the shell must not depend on the settings of whoever runs them.
+	if interactive && !*norc {
+		loadRC(sh)
+	}
+
+	// Interactive sessions keep a command history. It is loaded after the
+	// startup file, which may set HISTFILE, HISTSIZE and HISTFILESIZE.
+	if interactive {
+		if err := sh.LoadHistory(); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "shell:", err)
+		}
+	}
+
+	// lastPrompt is the prompt shown last, for the Ctrl+C handler. The
+	// handler must not build it itself: that reads the shell's variables,
+	// which the commands running at the same time may be changing.
+	var lastPrompt atomic.Pointer[string]
+
 	// This is for shell-like behavior.
 	// When you press Ctrl+C in shell,
 	// it prints something like username@host:cwd$ ^C on each line.
 	// So, minishell does so.
 	go func() {
 		for range sigCh {
-			// Build and print the shell prompt (username@host:cwd$)
-			prompt := makePrompt(u, host)
-			fmt.Println()
-			fmt.Print(prompt)
+			// Stop loops of builtins, which the signal does not kill.
+			shell.Interrupt()
+			if prompt := lastPrompt.Load(); prompt != nil {
+				fmt.Println()
+				fmt.Print(*prompt)
+			}
 		}
 	}()
 
+	// pending holds the lines of a command that is not complete yet,
+	// e.g. an if without its fi, entered over several lines.
+	var pending string
+
 	for {
-		// Build and print the shell prompt (username@host:cwd$)
-		prompt := makePrompt(u, host)
-		fmt.Print(prompt)
+		// Build and print the shell prompt (username@host:cwd$),
+		// or the continuation prompt (PS2) in the middle of a command.
+		prompt := makePrompt(sh, u, host)
+		if pending != "" {
+			prompt = continuationPrompt(sh)
+		}
+		lastPrompt.Store(&prompt)
 
 		// Read one line from stdin. Handles Ctrl+D (EOF) and errors internally.
-		line, err := readLine(reader)
+		line, err := readLine(prompt)
+		if errors.Is(err, lineedit.ErrInterrupted) {
+			// Ctrl+C in the line editor abandons the line, including the
+			// lines of an incomplete command.
+			pending = ""
+			continue
+		}
 		if err != nil {
 			if errors.Is(err, io.EOF) {
+				if pending != "" {
+					_, _ = fmt.Fprintln(os.Stderr, "\nshell: syntax error: unexpected end of file")
+				}
+
 				// Ctrl+D was pressed at an empty prompt: exit gracefully.
 				fmt.Println("\nexiting shell...")
 				return
@@ -68,17 +120,45 @@ func main() {
 			continue
 		}
 
+		// Expand history references such as !! and !$ in interactive
+		// sessions, showing the command that is actually run.
+		if interactive {
+			expanded, err := sh.ExpandHistory(line)
+			if err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, "shell:", err)
+				continue
+			}
+			if expanded != line {
+				fmt.Println(expanded)
+				line = expanded
+			}
+		}
+
 		// Execute the parsed command line
-		if err := sh.ExecuteLine(line); err != nil {
+		err = sh.ExecuteLine(pending + line)
+		if errors.Is(err, shell.ErrIncomplete) {
+			// The command continues on the next line.
+			pending += line + "\n"
+			continue
+		}
+		pending = ""
+
+		if err != nil {
 			var exitErr *exec.ExitError
 			if errors.As(err, &exitErr) {
 				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
 					if status.Signaled() && status.Signal() == syscall.SIGINT {
 						// Ignore Ctrl+C.
 						fmt.Println()
-						continue
 					}
 				}
+				continue
+			}
+
+			// Failed commands have already reported their errors.
+			var statusErr *shell.StatusError
+			if errors.As(err, &statusErr) {
+				continue
 			}
 
 			_, _ = fmt.Fprintln(os.Stderr, "shell:", err)
@@ -86,17 +166,49 @@ func main() {
 	}
 }
 
-func makePrompt(u *user.User, host string) string {
-	// Get current working directory.
-	dir, err := os.Getwd()
-	if err != nil {
-		log.Fatal(err)
+// loadRC executes the startup file in the shell of an interactive session: $MINISHELL_RC if set,
+// otherwise ~/.minishellrc. A missing default file is silently skipped.
+// Errors inside the file are reported with its name and line number.
+func loadRC(sh *shell.Shell) {
+	path, explicit := os.LookupEnv("MINISHELL_RC")
+	if !explicit {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return
+		}
+		path = filepath.Join(home, ".minishellrc")
+
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return
+		}
+	}
+
+	if err := sh.Source(path); err != nil {
+		// Failed commands have already reported their errors.
+		var statusErr *shell.StatusError
+		if !errors.As(err, &statusErr) {
+			_, _ = fmt.Fprintln(os.Stderr, "shell:", err)
+		}
+	}
+}
+
+func makePrompt(sh *shell.Shell, u *user.User, host string) string {
+	// Get the logical current working directory, as maintained by cd.
+	// If it cannot be determined (e.g. it was deleted from another terminal),
+	// show the last known path with a marker instead of exiting.
+	dir, err := sh.WorkingDir()
+	stale := err != nil
+	if stale && dir == "" {
+		dir = "?"
 	}
 
 	// Replace absolute home directory path with '~' (like in bash).
 	if strings.HasPrefix(dir, u.HomeDir) {
 		dir = strings.Replace(dir, u.HomeDir, "~", 1)
 	}
+	if stale {
+		dir += " (deleted)"
+	}
 
 	// Use ANSI escape codes for coloring (bold green for username and path).
 	boldGreen := "\033[1;32m"
@@ -109,6 +221,36 @@ func makePrompt(u *user.User, host string) string {
 	)
 }
 
+// lineReader returns the function reading a command line after showing the
+// prompt: the line editor if the shell is interactive (runs in a terminal),
+// or plain buffered reading otherwise (e.g. when input is piped). The editor
+// follows the editing mode selected with "set -o vi" or "set -o emacs",
+// recalls the commands of the shell's history and completes words with it.
+func lineReader(sh *shell.Shell, interactive bool) func(prompt string) (string, error) {
+	if interactive {
+		editor := lineedit.New(os.Stdin, os.Stdout)
+		editor.SetCompleter(func(line string, pos int) (int, []lineedit.Completion) {
+			start, candidates := sh.Complete(line, pos)
+			list := make([]lineedit.Completion, len(candidates))
+			for i, c := range candidates {
+				list[i] = lineedit.Completion{Text: c.Text, Display: c.Display, NoSpace: c.NoSpace}
+			}
+			return start, list
+		})
+		return func(prompt string) (string, error) {
+			editor.SetViMode(sh.Option("vi"))
+			editor.SetHistory(sh.History())
+			return editor.ReadLine(prompt)
+		}
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	return func(prompt string) (string, error) {
+		fmt.Print(prompt)
+		return readLine(reader)
+	}
+}
+
 func readLine(reader *bufio.Reader) (string, error) {
 	// Read a line from standard input.
 	line, err := reader.ReadString('\n')
@@ -121,6 +263,16 @@ func readLine(reader *bufio.Reader) (string, error) {
 		return "", fmt.Errorf("error reading input: %w", err)
 	}
 
-	// Trim spaces and trailing newline before returning the command.
-	return strings.TrimSpace(line), nil
+	// Trim the trailing newline before returning the command.
+	// Other whitespace is kept, as it matters inside multi-line quotes.
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// continuationPrompt returns the prompt shown while a command spans
+// several lines: the value of PS2, or "> " by default.
+func continuationPrompt(sh *shell.Shell) string {
+	if ps2, ok := sh.LookupVar("PS2"); ok {
+		return ps2
+	}
+	return "> "
 }
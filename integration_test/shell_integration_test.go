@@ -2,20 +2,54 @@ package integration_test
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
+	"unsafe"
 )
 
+// userEnv holds the variables of the user's environment that the shells of
+// the tests get in place, so that a test can still set them itself.
+var userEnv = map[string]string{
+	"HOME":          os.Getenv("HOME"),
+	"XDG_DATA_HOME": os.Getenv("XDG_DATA_HOME"),
+	"HISTFILE":      os.Getenv("HISTFILE"),
+}
+
+// shellEnv returns the environment of a shell started by a test. Unless the
+// test sets them, HOME, XDG_DATA_HOME and HISTFILE point to a temporary
+// directory, so that the user's startup file, z database and history are
+// left alone.
+func shellEnv(t *testing.T) []string {
+	dir := t.TempDir()
+	env := os.Environ()
+	for name, value := range map[string]string{
+		"HOME":          dir,
+		"XDG_DATA_HOME": filepath.Join(dir, ".local", "share"),
+		"HISTFILE":      filepath.Join(dir, ".minishell_history"),
+	} {
+		if os.Getenv(name) == userEnv[name] {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
 // runShell executes the minishell binary with given input lines
 // and returns combined stdout/stderr output.
 func runShell(t *testing.T, input string) string {
 	t.Helper()
 
-	cmd := exec.Command("../bin/minishell")
+	cmd := exec.Command("../bin/minishell", "--norc")
+	cmd.Env = shellEnv(t)
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		t.Fatal(err)
@@ -39,6 +73,84 @@ func runShell(t *testing.T, input string) string {
 		_ = "" // for linter
 	}
 
+	checkRaces(t, out.String())
+	return out.String()
+}
+
+// checkRaces fails the test if the shell, built with the race detector by
+// "make test-race", reported a data race.
+func checkRaces(t *testing.T, output string) {
+	t.Helper()
+	if strings.Contains(output, "WARNING: DATA RACE") {
+		t.Errorf("data race in the shell:\n%s", output)
+	}
+}
+
+// runShellTTY executes the minishell binary on a pseudo-terminal, so that the
+// line editor is used. Each chunk of keys is typed after a short pause, and
+// the shell is ended with Ctrl+D. It returns everything the shell displayed.
+func runShellTTY(t *testing.T, keys ...string) string {
+	t.Helper()
+	return runTTY(t, []string{"--norc"}, keys...)
+}
+
+// runTTY is runShellTTY with the arguments of the shell.
+func runTTY(t *testing.T, args []string, keys ...string) string {
+	t.Helper()
+
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %v", err)
+	}
+	defer func() { _ = ptmx.Close() }()
+
+	var n, unlock uint32
+	for _, req := range []struct {
+		op  uintptr
+		arg *uint32
+	}{{syscall.TIOCSPTLCK, &unlock}, {syscall.TIOCGPTN, &n}} {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ptmx.Fd(), req.op, uintptr(unsafe.Pointer(req.arg))); errno != 0 {
+			t.Fatal(errno)
+		}
+	}
+	pts, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("../bin/minishell", args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = pts, pts, pts
+	cmd.Env = shellEnv(t)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	_ = pts.Close()
+
+	var out bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(&out, ptmx)
+		close(done)
+	}()
+
+	for _, k := range append(keys, "\x04") {
+		time.Sleep(150 * time.Millisecond)
+		_, _ = ptmx.WriteString(k)
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- cmd.Wait() }()
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		_ = cmd.Process.Kill()
+		t.Error("shell did not exit")
+	}
+	_ = ptmx.Close()
+	<-done
+
+	checkRaces(t, out.String())
 	return out.String()
 }
 
@@ -55,14 +167,398 @@ func TestEchoBuiltin(t *testing.T) {
 	}
 }
 
+func TestEchoEscapesAndSpacing(t *testing.T) {
+	output := runShell(t, `echo "a   b" c 'd'
+echo -e 'x\ty\0101\x42\u00e9\cignored'; echo END
+echo -E 'raw\n' - -x
+echo -n hidden > /dev/null
+echo -n; echo shown
+`)
+	for _, want := range []string{"a   b c d\n", "x\tyABéEND", "raw\\n - -x", "shown"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+	if strings.Contains(output, "hidden") || strings.Contains(output, "ignored") {
+		t.Errorf("unexpected output, got %q", output)
+	}
+}
+
 func TestPwdAndCd(t *testing.T) {
-	home, _ := os.UserHomeDir()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
 	output := runShell(t, "cd ~\npwd\n")
 	if !strings.Contains(output, home) {
 		t.Errorf("expected %q in output, got %q", home, output)
 	}
 }
 
+func TestCdLogicalAndCdpath(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.MkdirAll(dir+"/real/sub", 0o755)
+	_ = os.MkdirAll(dir+"/projects/app", 0o755)
+	_ = os.Symlink(dir+"/real/sub", dir+"/link")
+
+	output := runShell(t, "cd "+dir+`/link
+echo "logical $PWD"
+sh -c 'echo "child $PWD"'
+cd ..; echo "parent $PWD from $OLDPWD"
+cd -P link; echo "physical $PWD"
+CDPATH=`+dir+`/projects
+cd app
+cd -L -x; echo "status $?"
+`)
+	for _, want := range []string{"logical " + dir + "/link", "child " + dir + "/link",
+		"parent " + dir + " from " + dir + "/link", "physical " + dir + "/real/sub",
+		dir + "/projects/app\n", "-x: invalid option", "status 1"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
+func TestPwdModesAndRemovedDirectory(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.MkdirAll(dir+"/real/gone", 0o755)
+	_ = os.Symlink(dir+"/real", dir+"/link")
+
+	output := runShell(t, "cd "+dir+`/link
+pwd; pwd -P
+cd gone
+rmdir `+dir+`/real/gone
+pwd; echo "status $?"
+pwd -P; echo "status $?"
+cd ..; pwd
+pwd extra; pwd -X
+`)
+	for _, want := range []string{dir + "/link\n" + dir + "/real\n", dir + "/link/gone\nstatus 0",
+		"gone (deleted)", "cannot get current directory", "status 1", "$ " + dir + "/link\n",
+		"pwd: too many arguments", "pwd: -X: invalid option"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
+func TestDirectoryStack(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.MkdirAll(dir+"/a", 0o755)
+	_ = os.MkdirAll(dir+"/b", 0o755)
+
+	output := runShell(t, "cd "+dir+`
+pushd a >/dev/null
+pushd ../b
+dirs -v
+echo tilde ~2
+pushd +2 >/dev/null; echo "rotated $PWD"
+popd >/dev/null; echo "popped $PWD"
+popd +1; popd; popd; echo "status $?"
+`)
+	for _, want := range []string{dir + "/b " + dir + "/a " + dir + "\n",
+		" 0  " + dir + "/b\n 1  " + dir + "/a\n 2  " + dir + "\n", "tilde " + dir + "\n",
+		"rotated " + dir + "\n", "popped " + dir + "/b\n", "directory stack empty", "status 1"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
+func TestZFrecency(t *testing.T) {
+	data := t.TempDir()
+	t.Setenv("XDG_DATA_HOME", data)
+	dir := t.TempDir()
+	for _, sub := range []string{"/services/billing/api", "/services/auth/api", "/old", "/script"} {
+		_ = os.MkdirAll(dir+sub, 0o755)
+	}
+
+	// Only interactive sessions record the directories.
+	runShell(t, "cd "+dir+"/script\n")
+
+	output := runShellTTY(t,
+		"cd "+dir+"/services/billing/api\r",
+		"cd ../../auth/api; cd /; cd "+dir+"/services/auth/api; cd "+dir+"/old; cd /\r",
+		"z api; echo \"jumped $PWD\"\r",
+		"z BILL api; echo \"status $?\"\r",
+		"z bill api; echo \"jumped $PWD\"\r",
+		"rmdir "+dir+"/old; z -l\r",
+		"z nothing; echo \"status $?\"\r",
+	)
+	for _, want := range []string{"jumped " + dir + "/services/auth/api\r\n", "status 1",
+		"jumped " + dir + "/services/billing/api\r\n", " " + dir + "/services/auth/api\r\n", "no match found"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+	if strings.Contains(output, dir+"/old\r\n") {
+		t.Errorf("expected removed directory to be skipped, got %q", output)
+	}
+
+	// Removed directories stay in the database until --prune.
+	saved, _ := os.ReadFile(filepath.Join(data, "minishell", "z"))
+	if !strings.Contains(string(saved), dir+"/old|") || strings.Contains(string(saved), dir+"/script|") {
+		t.Errorf("unexpected database %q", saved)
+	}
+	runShell(t, "z --prune\n")
+	saved, _ = os.ReadFile(filepath.Join(data, "minishell", "z"))
+	if strings.Contains(string(saved), dir+"/old|") || !strings.Contains(string(saved), dir+"/services/auth/api|") {
+		t.Errorf("expected --prune to remove the missing directory, got %q", saved)
+	}
+}
+
+func TestLineEditor(t *testing.T) {
+	output := runShellTTY(t,
+		"echo wrld\x1b[D\x1b[D\x1b[Do\r",              // arrows
+		"echo one two three\x17\x17\x01\x05 X\x19\r",  // Ctrl+W twice, Ctrl+A, Ctrl+E, Ctrl+Y
+		"echo h\u00e9llo \u65e5\u672c\x02\x02\x7f-\r", // UTF-8 and wide characters
+		"echo abc\x1bbX\x0b\r",                        // Alt+B, Ctrl+K
+		"echo foo\x1fbar\x1f\x1fecho undone\r",        // undo
+		"echo interrupted\x03",
+		"echo last\r",
+	)
+	for _, want := range []string{"\r\nworld\r\n", "\r\none Xtwo three\r\n", "\r\nh\u00e9llo-\u65e5\u672c\r\n",
+		"\r\nX\r\n", "\r\nundone\r\n", "^C\r\n", "\r\nlast\r\n", "exiting shell..."} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+	if strings.Contains(output, "\r\ninterrupted") {
+		t.Errorf("expected interrupted line not to run, got %q", output)
+	}
+}
+
+func TestLineEditorYankEmpty(t *testing.T) {
+	output := runShellTTY(t, "\x19", "\x1by", "echo ok\r")
+	if !strings.Contains(output, "\r\nok\r\n") {
+		t.Errorf("expected Alt+Y with an empty kill ring to be ignored, got %q", output)
+	}
+}
+
+func TestLineEditorViMode(t *testing.T) {
+	output := runShellTTY(t,
+		"set -o vi\r",
+		"echo one two three\x1bbcwTWO\x1b0wwdw$x.Ahey\x1bhhr_\r", // motions, operators, ".", r
+		"echo abc def\x1bFdd$p0fbyeP\r",                          // F, d$, p, ye, P
+		"echo aaa bbb ccc\x1b0w3x..\r",                           // counts and "."
+		"echo xyz\x1b0w2~u~\r",                                   // ~ and undo
+		"set -o\r",
+		"set -o emacs\r",
+		"echo back\x02\x02\x02X\r",
+	)
+	for _, want := range []string{"(ins) ", "(cmd) ", "\r\none T_ey\r\n", "\r\nabcbc def\r\n", "\r\ncc\r\n",
+		"\r\nXyz\r\n", "vi             \ton", "\r\nbXack\r\n"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	home := t.TempDir()
+	file := filepath.Join(home, ".minishell_history")
+	if err := os.WriteFile(file, []byte("#1700000000\necho from before\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("HISTFILE", file)
+	t.Setenv("HISTCONTROL", "ignoreboth")
+
+	output := runShellTTY(t,
+		"echo one\r",
+		"echo one\r",
+		" echo hidden\r",
+		"echo two\r",
+		"history -d 2\r",
+		"history\r",
+	)
+	for _, want := range []string{"    1  echo from before\r\n    2  echo two\r\n    3  history -d 2\r\n    4  history\r\n"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := string(data)
+	if !strings.HasPrefix(saved, "#1700000000\necho from before\n#") || strings.Contains(saved, "echo one") ||
+		strings.Contains(saved, "hidden") || !strings.HasSuffix(saved, "\nhistory\n") {
+		t.Errorf("unexpected history file %q", saved)
+	}
+
+	// A new session sees the saved commands; -c clears them, in the file too.
+	output = runShellTTY(t, "history 1\r", "history -c\r")
+	if !strings.Contains(output, "    5  history 1\r\n") {
+		t.Errorf("expected the history to continue, got %q", output)
+	}
+	if data, _ := os.ReadFile(file); len(data) != 0 {
+		t.Errorf("expected an empty history file, got %q", data)
+	}
+}
+
+func TestHistoryTimestampLikeCommand(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(file, []byte("#1700000000\n#123\n#1700000001\necho after\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HISTFILE", file)
+
+	output := runShellTTY(t, "history\r")
+	if !strings.Contains(output, "    1  #123\r\n    2  echo after\r\n    3  history\r\n") {
+		t.Errorf("expected the comment #123 to be read as a command, got %q", output)
+	}
+}
+
+func TestHistoryDeleteDuplicate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(file, []byte("#1700000000\necho dup\n#1700000000\necho dup\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HISTFILE", file)
+
+	runShellTTY(t, "history -d 1\r")
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(data), "\necho dup\n") != 1 {
+		t.Errorf("expected one of the two commands to be kept, got %q", data)
+	}
+}
+
+func TestHistoryNavigationAndSearch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(file, []byte("echo alpha\necho beta\necho alpine\nls\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HISTFILE", file)
+
+	output := runShellTTY(t,
+		"\x1b[A\x1b[A\r",                  // previous commands
+		"echo al\x1b[A\x1b[A\x1b[B-up\r",  // filtered by the typed prefix, down again
+		"\x12bet\r",                       // reverse search, Enter runs the match
+		"\x12alp\x12\x12\x1b[C\x1b[CX\r",  // older matches, skipping duplicates; arrows to edit
+		"\x12nothing\x07echo cancelled\r", // Ctrl+G restores the line
+	)
+	for _, want := range []string{
+		"\r\nalpine\r\n",
+		"\r\nalpine-up\r\n",
+		"(reverse-i-search)`bet': echo beta",
+		"\r\nbeta\r\n",
+		"(reverse-i-search)`alp': echo alpha",
+		"\r\nalXpha\r\n",
+		"(failed reverse-i-search)`nothing': ",
+		"\r\ncancelled\r\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
+func TestTabCompletion(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"My Documents", "music"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"notes.txt", "it's.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, file), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := runShellTTY(t,
+		"cd "+dir+"\r",
+		"zzfunc() { echo in function; }\r",
+		"zzfu\t\r",     // functions in command position
+		"echo M\t\r",   // directory, space escaped
+		"echo 'it\t\r", // quotes kept and closed
+		"ZZVAR=42\r",
+		"echo $ZZV\t\r",
+		"echo \t\t\x15echo listed\r", // double Tab lists the candidates
+	)
+	for _, want := range []string{
+		"\r\nin function\r\n",
+		"\r\nMy Documents/\r\n",
+		"\r\nit's.txt\r\n",
+		"\r\n42\r\n",
+		"My Documents/  it's.txt       music/         notes.txt\r\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
+func TestProgrammableCompletion(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "comp.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$1 $2 $COMP_POINT\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	output := runShellTTY(t,
+		"complete -W 'start stop status' echo\r",
+		"echo sto\t\r",                  // word list, filtered by the word
+		"echo sta\t\t\x15echo listed\r", // two candidates listed
+		"zzcomp() { COMPREPLY=(\"$COMP_CWORD-$2-$3\" \"${COMP_WORDS[0]}\"); }\r",
+		"complete -F zzcomp echo\r",
+		"echo a b\t\t\x15echo listed\r", // function candidates are not filtered
+		"zzcomp() { COMPREPLY=(\"$COMP_CWORD-$2-$3\"); }\r",
+		"echo a b\t\r",
+		"complete -C "+script+" echo\r",
+		"echo x\t\r", // command output
+		"complete -r echo\r",
+		"echo $COMP_LINE$COMPREPLY-unset\r",
+	)
+	for _, want := range []string{
+		"\r\nstop\r\n",
+		"start   status\r\n",
+		"2-b-a  echo\r\n",
+		"\r\na 2-b-a\r\n",
+		"\r\necho x 6\r\n",
+		"\r\n-unset\r\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+
+	output = runShell(t, "complete -o nospace -W 'a b' x y\ncomplete -F f z\ncomplete -r y\ncomplete\ncomplete -p y\na=(one \"two three\"\nfour); echo ${#a[@]} ${a[1]}\n")
+	for _, want := range []string{
+		"complete -o nospace -W 'a b' x\ncomplete -F f z\n",
+		"complete: y: no completion specification",
+		"3 two three",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
+func TestHistoryExpansion(t *testing.T) {
+	output := runShellTTY(t,
+		"echo one two /a/b/file.tar.gz\r",
+		"echo !$:h !$:t !$:r\r",
+		"echo !!:2 !*\r",
+		"^file^arch^\r",
+		"echo !ec:0 !?two?:1-2 '!!' \\!! x!\r",
+		"echo !nope\r",
+	)
+	for _, want := range []string{
+		"\r\necho /a/b file.tar.gz /a/b/file.tar\r\n/a/b file.tar.gz /a/b/file.tar\r\n",
+		"\r\necho file.tar.gz /a/b file.tar.gz /a/b/file.tar\r\n",
+		"\r\necho arch.tar.gz /a/b file.tar.gz /a/b/file.tar\r\n",
+		"\r\necho echo one two '!!' \\!! x!\r\necho one two !! !! x!\r\n",
+		"shell: !nope: event not found\r\n",
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
 func TestPipeline(t *testing.T) {
 	output := runShell(t, "echo hello world | wc -w\n")
 	if !strings.Contains(output, "2") {
@@ -70,6 +566,42 @@ func TestPipeline(t *testing.T) {
 	}
 }
 
+func TestPipelineStagesRunInSubshells(t *testing.T) {
+	output := runShell(t, `cd / | cat; echo "pwd $PWD"
+x=outer; x=inner | cat; echo "x=$x"
+export E=set | cat; echo "E=$E"
+alias q=echo | cat; alias
+f() { local y=local; z=global; echo "in $1 $y"; }
+f a | f b; echo "z=$z y=$y"
+`)
+	for _, want := range []string{"in b local\n", "x=outer\n", "E=\n", "z= y=\n"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+	if strings.Contains(output, "pwd /\n") || strings.Contains(output, "alias q=") {
+		t.Errorf("pipeline stage changed the shell, got %q", output)
+	}
+}
+
+func TestPipelineStageWorkingDirectory(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.Mkdir(dir+"/sub", 0o755)
+
+	output := runShell(t, "cd "+dir+`
+cd / | ls
+f() { cd sub; echo > made; ls; echo "pwd $PWD"; export V=stage; sh -c 'echo "child $V $PWD"'; }
+f | cat
+ls sub; echo "V=$V"
+`)
+	for _, want := range []string{"$ sub\n", "made\npwd " + dir + "/sub\nchild stage " + dir + "/sub\n",
+		"$ made\nV=\n"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
 func TestConditionalOperators(t *testing.T) {
 	output := runShell(t, "echo a && echo b\nfalse || echo ok\n")
 	if !strings.Contains(output, "b") || !strings.Contains(output, "ok") {
@@ -114,3 +646,314 @@ func TestPsAndKill(t *testing.T) {
 		}
 	}
 }
+
+func TestPsColumnsAndFilters(t *testing.T) {
+	output := runShell(t, `ps -p $$ -o pid=ID,ppid,user,stat,comm
+ps -p 1,$$ --sort -pid --no-headers -o pid,comm
+ps -o bogus; echo "status $?"
+`)
+	for _, want := range []string{" ID ", "PPID USER S CMD", "minishell", "bogus: unknown column\nstatus 1"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+	if i := strings.Index(output, "minishell\n"); i < 0 || !strings.Contains(output[i:], " 1 ") {
+		t.Errorf("expected processes sorted by descending PID, got %q", output)
+	}
+}
+
+func TestPsControlCharacters(t *testing.T) {
+	// The shell stays, running sleep, with an argument of several lines.
+	cmd := exec.Command("sh", "-c", "sleep 30; :", "multi\tline\nargument")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	output := runShell(t, fmt.Sprintf("ps -p %d -o pid,args; echo done\n", cmd.Process.Pid))
+	want := fmt.Sprintf("%d sh -c sleep 30; : multi line argument\ndone", cmd.Process.Pid)
+	if !strings.Contains(output, want) {
+		t.Errorf("expected %q in output, got %q", want, output)
+	}
+}
+
+func TestPsMachineFormats(t *testing.T) {
+	output := runShell(t, `ps -p $$ --format json
+ps -p $$ --format csv -o pid,comm
+ps -p $$ --format tsv --no-headers -o comm,ppid
+`)
+	for _, want := range []string{`"name":"minishell"`, `"pid":`, `"start":"`, "pid,name\n", ",minishell\n", "minishell\t"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
+func TestPsTree(t *testing.T) {
+	output := runShell(t, `f() { sleep 0.3; ps --tree=self -o pid,comm; }
+sleep 2 | f
+ps --tree=999999; echo "status $?"
+`)
+	for _, want := range []string{"└─ sleep", "minishell", "999999: no such process\nstatus 1"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+	if !strings.Contains(output, "\n*") {
+		t.Errorf("expected the shell's processes to be marked, got %q", output)
+	}
+}
+
+func TestTopBatch(t *testing.T) {
+	output := runShell(t, `top -b -n 2 -d 0.1 -o pid | grep -c '^top -'
+top -o bogus; echo "status $?"
+`)
+	for _, want := range []string{"2\n", "bogus: unknown sort column\nstatus 1"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
+func TestTopBatchInterrupt(t *testing.T) {
+	// The empty chunks give top time to print its first snapshot.
+	output := runShellTTY(t, "top -b -n 2 -d 60 >/dev/null\r", "", "", "\x03", "echo status $?\r")
+	if !strings.Contains(output, "\r\nstatus 130\r\n") {
+		t.Errorf("expected Ctrl+C to stop top -b, got %q", output)
+	}
+}
+
+func TestKillSignals(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	pid := cmd.Process.Pid
+
+	output := runShell(t, fmt.Sprintf(`kill -l 9 137 SIGTERM
+kill -0 %d; echo "alive $?"
+kill -s HUP 999999 %%1 %d; echo "status $?"
+kill -BOGUS 1; echo "status $?"
+`, pid, pid))
+	for _, want := range []string{"KILL\nKILL\n15", "alive 0", "(999999) - No such process", "%1: no such job",
+		"status 1", "BOGUS: invalid signal specification"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+
+	err := cmd.Wait()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Sys().(syscall.WaitStatus).Signal() != syscall.SIGHUP {
+		t.Errorf("expected process to be killed by SIGHUP, got %v", err)
+	}
+}
+
+func TestPgrepPkill(t *testing.T) {
+	// The durations include the PID of the test, so that the patterns only
+	// match the processes started here, not those of other test runs.
+	id := strconv.Itoa(os.Getpid())
+	var cmds []*exec.Cmd
+	for _, arg := range []string{"30." + id + "1", "30." + id + "2"} {
+		cmd := exec.Command("sleep", arg)
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		})
+		cmds = append(cmds, cmd)
+	}
+	older, newer := cmds[0].Process.Pid, cmds[1].Process.Pid
+
+	output := runShell(t, fmt.Sprintf(`pgrep -f '^sleep 30\.%[1]s[12]$'
+pgrep -l -o -f '^sleep 30\.%[1]s'
+pgrep -x -P %[2]d sleep; echo "status $?"
+pgrep -x 'no such process'; echo "status $?"
+pgrep -q; echo "status $?"
+pgrep -u nosuchuser; echo "user status $?"
+pkill -u 0,nosuchuser; echo "user status $?"
+pkill -KILL -n -f '^sleep 30\.%[1]s'; echo "killed $?"
+pkill -f '^sleep 30\.%[1]s1$'; echo "terminated $?"
+`, id, os.Getpid()))
+	for _, want := range []string{fmt.Sprintf("%d\n%d\n", older, newer), fmt.Sprintf("%d sleep\n", older),
+		fmt.Sprintf("%d\n%d\nstatus 0", older, newer), "status 1", "-q: invalid option", "status 2",
+		"pgrep: invalid user name: nosuchuser\nuser status 2", "pkill: invalid user name: nosuchuser\nuser status 2", "killed 0", "terminated 0"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+
+	for i, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL} {
+		err := cmds[i].Wait()
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.Sys().(syscall.WaitStatus).Signal() != sig {
+			t.Errorf("expected process %d to be killed by %v, got %v", i, sig, err)
+		}
+	}
+}
+
+func TestControlFlow(t *testing.T) {
+	output := runShell(t, `for i in a "b c" d; do
+  if [ "$i" = a ]; then echo first; elif [ "$i" = d ]; then echo last; else echo "mid[$i]"; fi
+done
+n=0
+while true; do for j in 1 2 3; do if [ $j = 2 ]; then continue 2; fi; echo j$j; done; done | head -2
+until false; do echo once; break; done
+`)
+	for _, want := range []string{"first", "mid[b c]", "last", "j1\nj1", "once"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
+func TestForBreakNested(t *testing.T) {
+	output := runShell(t, "for x in 1 2; do for y in a b; do echo got$x$y; break 2; done; done\n")
+	if !strings.Contains(output, "got1a") || strings.Contains(output, "got1b") || strings.Contains(output, "got2a") {
+		t.Errorf("break 2 failed, got %q", output)
+	}
+}
+
+func TestInterruptBuiltinLoop(t *testing.T) {
+	output := runShellTTY(t,
+		"while true; do :; done; echo after\r",
+		"\x03",
+		"echo status $?\r",
+	)
+	if !strings.Contains(output, "\r\nstatus 130\r\n") || strings.Contains(output, "\r\nafter\r\n") {
+		t.Errorf("expected Ctrl+C to stop the loop and the line, got %q", output)
+	}
+}
+
+func TestCase(t *testing.T) {
+	output := runShell(t, `for w in main.go a/b "x y" Q; do case $w in
+  *.go) echo go:$w ;;
+  */*) echo path:$w ;&
+  never) echo fell ;;
+  "x "*) echo space ;;
+  [A-Z]) echo upper ;;&
+  ?) echo single ;;
+esac; done
+case '*' in "*") echo star ;; *) echo any ;; esac
+`)
+	for _, want := range []string{"go:main.go", "path:a/b\nfell", "space", "upper\nsingle", "star"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	output := runShell(t, `greet() { echo "hello $1 ($#)"; }
+greet world x
+x=global
+show() { echo "x=$x"; }
+outer() { local x=outer; show; inner; echo "after inner x=$x"; }
+inner() { x=changed; }
+outer; echo "top x=$x"
+function early { for i in 1 2 3; do if [ $i = 2 ]; then return 7; fi; echo i$i; done; }
+early; echo "status $?"
+deep() { deep; }
+deep; echo survived
+`)
+	for _, want := range []string{"hello world (2)", "x=outer", "after inner x=changed", "top x=global",
+		"i1\nstatus 7", "maximum function nesting level exceeded", "survived"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
+func TestSourceAndRCFile(t *testing.T) {
+	dir := t.TempDir()
+	rc := dir + "/rc"
+	lib := dir + "/lib.sh"
+	_ = os.WriteFile(rc, []byte("greet() {\n  echo \"hi $1\"\n}\nexport RCVAR=fromrc\ncd /nonexistent\n"), 0o644)
+	_ = os.WriteFile(lib, []byte("echo \"lib args: $# $1\"\nreturn 4\necho never\n"), 0o644)
+	t.Setenv("MINISHELL_RC", rc)
+
+	output := runTTY(t, nil, "greet you\r", "echo $RCVAR\r", "source "+lib+" a b; echo status $?\r")
+	for _, want := range []string{"hi you", "fromrc", rc + ":5: cd:", "lib args: 2 a\r\nstatus 4"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+	if strings.Contains(output, "never") {
+		t.Errorf("return did not stop the sourced file, got %q", output)
+	}
+
+	// Scripts piped to the shell do not read the startup file.
+	cmd := exec.Command("../bin/minishell")
+	cmd.Env = shellEnv(t)
+	cmd.Stdin = strings.NewReader("echo \"rc var: $RCVAR\"\n")
+	out, _ := cmd.CombinedOutput()
+	if !strings.Contains(string(out), "rc var: \n") {
+		t.Errorf("expected the startup file to be skipped, got %q", out)
+	}
+}
+
+func TestAlias(t *testing.T) {
+	output := runShell(t, `alias say='echo said ' word=hello p='echo a | tr a b' loop1=loop2 loop2=loop1
+say word
+p
+loop1
+alias say
+unalias -a
+say word
+`)
+	for _, want := range []string{"said hello", "b\n", "loop1: command not found", "alias say='echo said '", "say: command not found"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
+func TestTestBuiltins(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.WriteFile(dir+"/old", nil, 0o644)
+	_ = os.WriteFile(dir+"/new", nil, 0o644)
+	_ = os.Chtimes(dir+"/old", time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+
+	output := runShell(t, "cd "+dir+`
+[ -f new ] && [ ! -d new ] && echo files
+[ new -nt old ] && test old -ot new && echo newer
+[ a = b -o 1 -lt 2 ] && echo or
+x=aab v="a b"
+[[ $x == a* && $x != "a*" ]] && echo pattern
+[[ $x =~ ^(a+)(b)$ ]] && echo "match ${BASH_REMATCH[1]} ${#BASH_REMATCH[@]}"
+[[ ab =~ (a|ab) ]] && echo "longest ${BASH_REMATCH[0]}"
+[[ $v == "a b" ]] && echo nosplit
+[ 1 -eq x ]; echo status $?
+[[ a =~ ( ]]; echo status $?
+`)
+	for _, want := range []string{"files", "newer", "or", "pattern", "match aa 3", "longest ab\n", "nosplit",
+		"integer expression expected\nstatus 2", "status 2\n"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
+
+func TestPrintf(t *testing.T) {
+	output := runShell(t, `printf '%s|%5s|%-4s|%.2s\n' abc de fg hijk
+printf '%d %x %#o %5.2f %c\n' "'A" 255 8 3.14159 word
+printf '[%s]' a b c; printf '\n'
+printf '%b|%q\n' 'tab\there\0101' "it's"
+printf -v out '%03d' 7; echo "out=$out"
+printf '%d\n' 12abc; echo "status $?"
+printf '%d %d\n' 0x1Fz 0755abc
+printf '%d %d %x\n' 99999999999999999999 -99999999999999999999 0xffffffffffffffff; echo "range $?"
+`)
+	for _, want := range []string{"abc|   de|fg  |hi", "65 ff 010  3.14 w", "[a][b][c]",
+		"tab\thereA|'it'\\''s'", "out=007", "12abc: invalid number", "status 1", "31 493\n",
+		"99999999999999999999: Result too large", "9223372036854775807 -9223372036854775808 ffffffffffffffff\nrange 1"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("expected %q in output, got %q", want, output)
+		}
+	}
+}
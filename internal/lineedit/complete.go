@@ -0,0 +1,133 @@
+package lineedit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// completionQueryItems is the number of candidates above which the user is
+// asked before they are listed.
+const completionQueryItems = 100
+
+// Completion is a candidate for completing the word before the cursor.
+type Completion struct {
+	Text    string // text replacing the word
+	Display string // text shown when the candidates are listed
+	NoSpace bool   // no space is added after a unique completion
+}
+
+// Completer returns the candidates for completing the word of line that ends
+// at pos, and the position where that word starts. Positions count runes.
+type Completer func(line string, pos int) (start int, candidates []Completion)
+
+// SetCompleter sets the function that Tab uses to complete words.
+func (e *Editor) SetCompleter(c Completer) {
+	e.completer = c
+}
+
+// complete completes the word before the cursor. A unique candidate replaces
+// the word, followed by a space. With several candidates the word is extended
+// to their longest common prefix; if it cannot be extended, a second Tab
+// lists the candidates in columns below the line.
+func (e *Editor) complete() {
+	if e.completer == nil {
+		return
+	}
+	start, candidates := e.completer(string(e.buf), e.pos)
+	if start < 0 || start > e.pos {
+		return
+	}
+
+	switch len(candidates) {
+	case 0:
+		e.write("\a")
+		return
+	case 1:
+		text := candidates[0].Text
+		if !candidates[0].NoSpace {
+			text += " "
+		}
+		e.replaceWord(start, text)
+		return
+	}
+
+	prefix := candidates[0].Text
+	for _, c := range candidates[1:] {
+		n := 0
+		for n < len(prefix) && n < len(c.Text) && prefix[n] == c.Text[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	if word := string(e.buf[start:e.pos]); len(prefix) > len(word) && strings.HasPrefix(prefix, word) ||
+		len(prefix) > 0 && !strings.HasPrefix(prefix, word) {
+		e.replaceWord(start, strings.ToValidUTF8(prefix, ""))
+		return
+	}
+
+	if e.last != keyTab {
+		e.write("\a")
+		return
+	}
+	e.listCandidates(candidates)
+}
+
+// replaceWord replaces the text from start to the cursor by text.
+func (e *Editor) replaceWord(start int, text string) {
+	e.save("complete")
+	e.buf = append(e.buf[:start], append([]rune(text), e.buf[e.pos:]...)...)
+	e.pos = start + len([]rune(text))
+}
+
+// listCandidates prints the candidates below the line in columns, ordered
+// down the columns like ls does, then draws the line again. Above
+// completionQueryItems candidates, the user is asked first.
+func (e *Editor) listCandidates(candidates []Completion) {
+	pos := e.pos
+	e.pos = len(e.buf)
+	e.refresh()
+	e.pos = pos
+	e.write("\r\n")
+	e.cursorRow = 0
+
+	if len(candidates) > completionQueryItems {
+		e.write(fmt.Sprintf("Display all %d possibilities? (y or n)", len(candidates)))
+		k, err := e.key()
+		e.write("\r\n")
+		if err != nil || k != "y" && k != "Y" {
+			return
+		}
+	}
+
+	colWidth := 0
+	for _, c := range candidates {
+		colWidth = max(colWidth, displayWidth(c.Display)+2)
+	}
+	cols := max(e.width/colWidth, 1)
+	rows := (len(candidates) + cols - 1) / cols
+
+	var b strings.Builder
+	for row := range rows {
+		for col := range cols {
+			i := col*rows + row
+			if i >= len(candidates) {
+				break
+			}
+			b.WriteString(candidates[i].Display)
+			if col < cols-1 && i+rows < len(candidates) {
+				b.WriteString(strings.Repeat(" ", colWidth-displayWidth(candidates[i].Display)))
+			}
+		}
+		b.WriteString("\r\n")
+	}
+	e.write(b.String())
+}
+
+// displayWidth returns the number of columns taken by s on the terminal.
+func displayWidth(s string) int {
+	n := 0
+	for _, r := range visibleRunes(s) {
+		n += runeWidth(r)
+	}
+	return n
+}
@@ -0,0 +1,499 @@
+// Package lineedit implements the interactive line editor of minishell.
+// It puts the terminal in raw mode while a line is read and supports cursor
+// movement, Emacs or vi key bindings with a kill ring and undo, history
+// navigation and search, word completion, UTF-8 and wide characters, lines
+// wrapping over several rows and terminal resizes.
+package lineedit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/aliskhannn/minishell/internal/term"
+)
+
+// ErrInterrupted is returned by ReadLine when the line is abandoned with Ctrl+C.
+var ErrInterrupted = errors.New("interrupted")
+
+// killRingSize is the number of killed texts kept for yanking.
+const killRingSize = 16
+
+// pollInterval is how often, in milliseconds, ReadLine checks for signals
+// (such as a terminal resize) while waiting for input.
+const pollInterval = 100
+
+// Editor reads lines from a terminal. The kill ring, the last vi change and
+// the last vi character search are kept across lines.
+type Editor struct {
+	in  *os.File
+	out *os.File
+	fd  int
+
+	vi           bool      // vi key bindings instead of Emacs ones
+	kills        []string  // kill ring, most recent last
+	lastChange   *viChange // last change in vi normal mode, for "."
+	lastFind     string    // last vi character search command (f, F, t or T)
+	lastFindRune rune      // character of the last vi character search
+	history      []string  // commands recalled with Up and Down, oldest first
+	lastSearch   string    // query of the last incremental search
+	completer    Completer // completes words on Tab
+
+	// Input of the line being read.
+	sigs  chan os.Signal // terminal resizes and terminating signals
+	state *term.State    // terminal state to restore
+
+	// vi commands being replayed by "." or recorded for it.
+	replay      []string
+	split       string // key typed right after Escape, returned after it
+	recording   bool
+	recorded    []string
+	changeCount int
+
+	// State of the line being read.
+	prompt    string
+	buf       []rune
+	pos       int        // cursor position in buf
+	width     int        // terminal width in columns
+	cursorRow int        // row of the cursor, relative to the first row of the prompt
+	undo      []snapshot // states before the last changes
+	last      string     // previous command, to group inserts and kills
+	ctrlX     bool       // Ctrl+X was typed, waiting for the second key
+	normal    bool       // vi normal (command) mode
+	yankStart int        // position of the last yanked text in buf, for M-y
+	yankIndex int        // kill ring entry of the last yank
+
+	// Navigation through the history.
+	histIndex  int     // command of the history shown, len(history) for the line being typed
+	histDraft  []rune  // line being typed, while commands of the history are shown
+	histPrefix string  // text that the commands shown with Up and Down start with
+	search     *search // incremental search in progress
+}
+
+// snapshot is a state of the line that undo returns to.
+type snapshot struct {
+	buf []rune
+	pos int
+}
+
+// New returns an editor reading from in, which must be a terminal, and
+// drawing on out.
+func New(in, out *os.File) *Editor {
+	return &Editor{in: in, out: out, fd: int(in.Fd())}
+}
+
+// ReadLine shows the prompt and lets the user edit a line until Enter is
+// pressed. It returns io.EOF if Ctrl+D is pressed on an empty line and
+// ErrInterrupted for Ctrl+C. The terminal is restored before returning,
+// also if the editor panics, and if the shell is terminated by a signal
+// while waiting for input.
+func (e *Editor) ReadLine(prompt string) (string, error) {
+	state, err := term.MakeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = term.Restore(e.fd, state) }()
+
+	e.state = state
+	e.sigs = make(chan os.Signal, 1)
+	signal.Notify(e.sigs, syscall.SIGWINCH)
+	for _, sig := range []os.Signal{syscall.SIGTERM, syscall.SIGHUP} {
+		if !signal.Ignored(sig) {
+			signal.Notify(e.sigs, sig)
+		}
+	}
+	defer signal.Stop(e.sigs)
+
+	e.prompt, e.buf, e.pos, e.cursorRow = prompt, nil, 0, 0
+	e.undo, e.last, e.ctrlX, e.normal = nil, "", false, false
+	e.replay, e.split, e.recording = nil, "", false
+	e.histIndex, e.histDraft, e.search = len(e.history), nil, nil
+	e.updateWidth()
+	e.refresh()
+
+	for {
+		k, err := e.key()
+		if err != nil {
+			e.write("\r\n")
+			return "", err
+		}
+
+		line, done, err := e.dispatch(k)
+		if done || err != nil {
+			return line, err
+		}
+	}
+}
+
+// dispatch handles a key according to the editing mode. done is set when the
+// line is complete.
+func (e *Editor) dispatch(k string) (line string, done bool, err error) {
+	switch {
+	case e.search != nil:
+		return e.searchKey(k)
+	case e.vi && e.normal:
+		return e.viNormalKey(k)
+	case e.vi:
+		return e.viInsertKey(k)
+	default:
+		return e.handleKey(k)
+	}
+}
+
+// nextKey waits for the next key press. Meanwhile it redraws the line when
+// the terminal is resized and restores the terminal before the shell is
+// terminated by SIGTERM or SIGHUP.
+func (e *Editor) nextKey() (string, error) {
+	for {
+		select {
+		case sig := <-e.sigs:
+			if sig == syscall.SIGWINCH {
+				e.updateWidth()
+				e.refresh()
+				continue
+			}
+
+			// Restore the terminal, then die from the signal as if it had
+			// not been caught.
+			_ = term.Restore(e.fd, e.state)
+			signal.Reset(sig)
+			_ = syscall.Kill(os.Getpid(), sig.(syscall.Signal))
+			return "", io.EOF
+		default:
+		}
+
+		ready, err := term.WaitInput(e.fd, pollInterval)
+		if err != nil {
+			return "", err
+		}
+		if ready {
+			return e.readKey()
+		}
+	}
+}
+
+// handleKey performs the command bound to a key. done is set when the line
+// is complete.
+func (e *Editor) handleKey(k string) (line string, done bool, err error) {
+	cmd := k
+	if e.ctrlX {
+		e.ctrlX = false
+		cmd = "C-x " + k
+	}
+
+	switch cmd {
+	case keyEnter:
+		e.pos = len(e.buf)
+		e.refresh()
+		e.write("\r\n")
+		return string(e.buf), true, nil
+	case "C-c":
+		e.pos = len(e.buf)
+		e.refresh()
+		e.write("^C\r\n")
+		return "", true, ErrInterrupted
+	case "C-d":
+		if len(e.buf) == 0 {
+			return "", true, io.EOF
+		}
+		e.deleteRange(e.pos, e.pos+1)
+	case "C-x":
+		e.ctrlX = true
+		return "", false, nil
+
+	// Movement.
+	case "C-a", keyHome:
+		e.pos = 0
+	case "C-e", keyEnd:
+		e.pos = len(e.buf)
+	case "C-b", keyLeft:
+		e.pos = max(e.pos-1, 0)
+	case "C-f", keyRight:
+		e.pos = min(e.pos+1, len(e.buf))
+	case "M-b", keyCtrlLeft:
+		e.pos = e.wordStart(e.pos)
+	case "M-f", keyCtrlRight:
+		e.pos = e.wordEnd(e.pos)
+
+	// History.
+	case "C-p", keyUp:
+		e.historyMove(-1)
+	case "C-n", keyDown:
+		e.historyMove(1)
+	case "C-r", "C-s":
+		e.startSearch(cmd == "C-s")
+
+	// Deletion and the kill ring.
+	case keyBackspace:
+		e.deleteRange(e.pos-1, e.pos)
+	case keyDelete:
+		e.deleteRange(e.pos, e.pos+1)
+	case "C-k":
+		e.kill(e.pos, len(e.buf))
+	case "C-u":
+		e.kill(0, e.pos)
+	case "C-w":
+		// Kill the previous whitespace-delimited word.
+		start := e.pos
+		for start > 0 && unicode.IsSpace(e.buf[start-1]) {
+			start--
+		}
+		for start > 0 && !unicode.IsSpace(e.buf[start-1]) {
+			start--
+		}
+		e.kill(start, e.pos)
+	case "M-d":
+		e.kill(e.pos, e.wordEnd(e.pos))
+	case "M-" + keyBackspace:
+		e.kill(e.wordStart(e.pos), e.pos)
+	case "C-y":
+		if len(e.kills) == 0 {
+			// Nothing yanked: a following Alt+Y has nothing to replace.
+			cmd = ""
+			break
+		}
+		e.yank()
+	case "M-y":
+		if e.last != "C-y" && e.last != "M-y" {
+			cmd = ""
+			break
+		}
+		e.yankPop()
+
+	// Other editing commands.
+	case keyTab:
+		e.complete()
+	case "C-t":
+		e.transpose()
+	case keyUndo, "C-x C-u":
+		e.undoLast()
+	case "C-l":
+		e.write("\x1b[H\x1b[2J")
+		e.cursorRow = 0
+	default:
+		if r, size := utf8.DecodeRuneInString(k); size == len(k) && unicode.IsPrint(r) {
+			cmd = "insert"
+			e.insert([]rune{r})
+		}
+	}
+
+	e.last = cmd
+	e.refresh()
+	return "", false, nil
+}
+
+// save records the current state for undo. Consecutive inserted characters
+// are undone together.
+func (e *Editor) save(cmd string) {
+	if cmd == "insert" && e.last == "insert" {
+		return
+	}
+	e.undo = append(e.undo, snapshot{buf: append([]rune(nil), e.buf...), pos: e.pos})
+}
+
+// undoLast returns to the state before the last change.
+func (e *Editor) undoLast() {
+	if n := len(e.undo); n > 0 {
+		e.buf, e.pos = e.undo[n-1].buf, e.undo[n-1].pos
+		e.undo = e.undo[:n-1]
+	}
+}
+
+// insert inserts text at the cursor and moves the cursor after it.
+func (e *Editor) insert(text []rune) {
+	e.save("insert")
+	e.buf = append(e.buf[:e.pos], append(text, e.buf[e.pos:]...)...)
+	e.pos += len(text)
+}
+
+// deleteRange removes buf[start:end], clamped to the line, and leaves the
+// cursor at start.
+func (e *Editor) deleteRange(start, end int) []rune {
+	start, end = max(start, 0), min(end, len(e.buf))
+	if start >= end {
+		return nil
+	}
+	e.save("delete")
+	removed := append([]rune(nil), e.buf[start:end]...)
+	e.buf = append(e.buf[:start], e.buf[end:]...)
+	e.pos = start
+	return removed
+}
+
+// kill deletes buf[start:end] into the kill ring. Consecutive kills are
+// joined into one entry, in the order of the text on the line.
+func (e *Editor) kill(start, end int) {
+	forward := start == e.pos
+	text := string(e.deleteRange(start, end))
+	if text == "" {
+		return
+	}
+
+	if n := len(e.kills); n > 0 && isKill(e.last) {
+		if forward {
+			e.kills[n-1] += text
+		} else {
+			e.kills[n-1] = text + e.kills[n-1]
+		}
+		return
+	}
+
+	e.pushKill(text)
+}
+
+// isKill reports whether cmd is one of the commands that kill text.
+func isKill(cmd string) bool {
+	switch cmd {
+	case "C-k", "C-u", "C-w", "M-d", "M-" + keyBackspace:
+		return true
+	}
+	return false
+}
+
+// yank inserts the most recently killed text.
+func (e *Editor) yank() {
+	if len(e.kills) == 0 {
+		return
+	}
+	e.yankStart, e.yankIndex = e.pos, len(e.kills)-1
+	e.insert([]rune(e.kills[e.yankIndex]))
+}
+
+// yankPop replaces the text just yanked with the previous kill ring entry.
+func (e *Editor) yankPop() {
+	if len(e.kills) == 0 {
+		return
+	}
+	prev := []rune(e.kills[e.yankIndex])
+	e.yankIndex = (e.yankIndex + len(e.kills) - 1) % len(e.kills)
+	text := []rune(e.kills[e.yankIndex])
+
+	e.save("yank")
+	e.buf = append(e.buf[:e.yankStart], append(text, e.buf[e.yankStart+len(prev):]...)...)
+	e.pos = e.yankStart + len(text)
+}
+
+// transpose exchanges the characters before and at the cursor (or the two
+// before it at the end of the line) and moves the cursor forward.
+func (e *Editor) transpose() {
+	if len(e.buf) < 2 || e.pos == 0 {
+		return
+	}
+	if e.pos == len(e.buf) {
+		e.pos--
+	}
+	e.save("transpose")
+	e.buf[e.pos-1], e.buf[e.pos] = e.buf[e.pos], e.buf[e.pos-1]
+	e.pos++
+}
+
+// isWordRune reports whether r is part of a word for the word motions.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// wordStart returns the start of the word before pos.
+func (e *Editor) wordStart(pos int) int {
+	for pos > 0 && !isWordRune(e.buf[pos-1]) {
+		pos--
+	}
+	for pos > 0 && isWordRune(e.buf[pos-1]) {
+		pos--
+	}
+	return pos
+}
+
+// wordEnd returns the end of the word after pos.
+func (e *Editor) wordEnd(pos int) int {
+	for pos < len(e.buf) && !isWordRune(e.buf[pos]) {
+		pos++
+	}
+	for pos < len(e.buf) && isWordRune(e.buf[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// updateWidth reads the width of the terminal, assuming 80 columns if it is unknown.
+func (e *Editor) updateWidth() {
+	e.width = 80
+	if w, _, err := term.GetSize(int(e.out.Fd())); err == nil && w > 0 {
+		e.width = w
+	}
+}
+
+// write writes s to the terminal.
+func (e *Editor) write(s string) {
+	_, _ = e.out.WriteString(s)
+}
+
+// refresh redraws the prompt and the line, which may wrap over several
+// rows, and puts the cursor in place.
+func (e *Editor) refresh() {
+	var b strings.Builder
+
+	// Go back to the first row of the prompt and clear everything below.
+	if e.cursorRow > 0 {
+		_, _ = fmt.Fprintf(&b, "\x1b[%dA", e.cursorRow)
+	}
+	prompt := e.modeIndicator() + e.prompt
+	if e.search != nil {
+		prompt = e.searchPrompt()
+	}
+	b.WriteString("\r\x1b[J")
+	b.WriteString(prompt)
+	// Commands recalled from the history may span several lines.
+	b.WriteString(strings.ReplaceAll(string(e.buf), "\n", "\r\n"))
+
+	// Lay out the prompt and the line like the terminal does, to find the
+	// rows and columns of the cursor and of the end of the line.
+	row, col := 0, 0
+	place := func(r rune) {
+		if r == '\n' {
+			row, col = row+1, 0
+			return
+		}
+		w := runeWidth(r)
+		if col+w > e.width {
+			// A character that does not fit goes to the next row.
+			row, col = row+1, 0
+		}
+		col += w
+		if col >= e.width {
+			row, col = row+1, 0
+		}
+	}
+	for _, r := range visibleRunes(prompt) {
+		place(r)
+	}
+	curRow, curCol := row, col
+	for i, r := range e.buf {
+		place(r)
+		if i+1 == e.pos {
+			curRow, curCol = row, col
+		}
+	}
+
+	// The terminal does not wrap until the next character is written, so
+	// move to the next row explicitly when the line fills the last one.
+	if col == 0 && row > 0 && (len(e.buf) == 0 || e.buf[len(e.buf)-1] != '\n') {
+		b.WriteString("\r\n")
+	}
+
+	if up := row - curRow; up > 0 {
+		_, _ = fmt.Fprintf(&b, "\x1b[%dA", up)
+	}
+	b.WriteString("\r")
+	if curCol > 0 {
+		_, _ = fmt.Fprintf(&b, "\x1b[%dC", curCol)
+	}
+	e.cursorRow = curRow
+
+	e.write(b.String())
+}
@@ -0,0 +1,253 @@
+package lineedit
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// search is the state of an incremental history search (Ctrl+R or Ctrl+S).
+type search struct {
+	forward bool
+	query   string
+	failed  bool       // no command matches the query
+	steps   []searchAt // matches before each character of the query, for Backspace
+	at      searchAt   // current match
+
+	// Line before the search, restored by Ctrl+G.
+	buf       []rune
+	pos       int
+	histIndex int
+}
+
+// searchAt is a match of the incremental search: a command of the history
+// and the position of the query in it.
+type searchAt struct {
+	index, pos int
+	failed     bool
+}
+
+// SetHistory sets the commands, oldest first, that the next lines can recall
+// with Up and Down and the incremental search.
+func (e *Editor) SetHistory(lines []string) {
+	e.history = lines
+}
+
+// isHistoryMove reports whether cmd moves through the history.
+func isHistoryMove(cmd string) bool {
+	switch cmd {
+	case keyUp, keyDown, "C-p", "C-n", "k", "j", "-", "+":
+		return true
+	}
+	return false
+}
+
+// historyMove replaces the line by the previous (dir < 0) or next (dir > 0)
+// command of the history that starts with the text typed before moving
+// through the history. Going down past the newest command brings back the
+// text that was typed.
+func (e *Editor) historyMove(dir int) {
+	if !isHistoryMove(e.last) {
+		e.histPrefix = string(e.buf)
+		if e.histIndex == len(e.history) {
+			e.histDraft = slices.Clone(e.buf)
+		}
+	}
+
+	current := string(e.buf)
+	for i := e.histIndex + dir; i >= 0 && i <= len(e.history); i += dir {
+		if i == len(e.history) {
+			e.histIndex = i
+			e.setLine(e.histDraft, len(e.histDraft))
+			return
+		}
+		// Skip the commands equal to the one shown, so that duplicates
+		// do not take several key presses.
+		if cmd := e.history[i]; strings.HasPrefix(cmd, e.histPrefix) && cmd != current {
+			e.histIndex = i
+			e.setLine([]rune(cmd), utf8.RuneCountInString(cmd))
+			return
+		}
+	}
+}
+
+// setLine replaces the line by text, with the cursor at pos. Changes made to
+// the previous line can no longer be undone.
+func (e *Editor) setLine(text []rune, pos int) {
+	e.buf, e.pos, e.undo = slices.Clone(text), pos, nil
+}
+
+// startSearch starts an incremental search through the history, backward
+// (Ctrl+R) or forward (Ctrl+S) from the line shown.
+func (e *Editor) startSearch(forward bool) {
+	e.search = &search{
+		forward:   forward,
+		at:        searchAt{index: e.histIndex, pos: e.pos},
+		buf:       slices.Clone(e.buf),
+		pos:       e.pos,
+		histIndex: e.histIndex,
+	}
+}
+
+// searchKey handles a key during an incremental search. Typed characters
+// extend the query and Backspace shortens it; Ctrl+R and Ctrl+S find the
+// previous or next match (repeating the last search if the query is empty).
+// Enter accepts the line found, Escape ends the search to edit it, and
+// Ctrl+G abandons the search, bringing back the line from before. Other keys
+// end the search and are handled as usual, so that moving the cursor starts
+// editing the line found.
+func (e *Editor) searchKey(k string) (string, bool, error) {
+	s := e.search
+
+	switch k {
+	case "C-r", "C-s":
+		s.forward = k == "C-s"
+		if s.query == "" {
+			s.query = e.lastSearch
+			e.searchMatch(s.at, true)
+			break
+		}
+		// The next match, before (or after) the current one.
+		at := s.at
+		switch {
+		case s.forward:
+			at.pos++
+		case at.pos == 0:
+			at.index, at.pos = at.index-1, -1
+		default:
+			at.pos--
+		}
+		e.searchMatch(at, false)
+	case keyBackspace:
+		if n := len(s.steps); n > 0 {
+			_, size := utf8.DecodeLastRuneInString(s.query)
+			s.query = s.query[:len(s.query)-size]
+			s.at, s.steps = s.steps[n-1], s.steps[:n-1]
+			s.failed = s.at.failed
+			switch {
+			case s.failed:
+			case s.at.index < len(e.history):
+				e.histIndex = s.at.index
+				e.setLine([]rune(e.history[s.at.index]), s.at.pos)
+			default:
+				e.histIndex = s.histIndex
+				e.setLine(s.buf, s.pos)
+			}
+		}
+	case "C-g":
+		e.setLine(s.buf, s.pos)
+		e.histIndex = s.histIndex
+		e.endSearch()
+	case keyEscape:
+		e.endSearch()
+	case keyEnter:
+		e.endSearch()
+		return e.dispatch(k)
+	default:
+		r, size := utf8.DecodeRuneInString(k)
+		if size != len(k) || !unicode.IsPrint(r) {
+			e.endSearch()
+			return e.dispatch(k)
+		}
+		s.steps = append(s.steps, searchAt{index: s.at.index, pos: s.at.pos, failed: s.failed})
+		s.query += k
+		e.searchMatch(s.at, true)
+	}
+
+	e.refresh()
+	return "", false, nil
+}
+
+// searchMatch looks for the query from the given command and position, in
+// the direction of the search, and shows the match. If there is none, the
+// search is marked as failed and the line is left as it is.
+func (e *Editor) searchMatch(from searchAt, grow bool) {
+	s := e.search
+	if s.query == "" {
+		return
+	}
+	if s.failed && !grow {
+		// Nothing more to find in this direction.
+		return
+	}
+
+	index, pos := from.index, from.pos
+	if index >= len(e.history) {
+		// The search starts from the line being typed.
+		index, pos = len(e.history)-1, -1
+		if s.forward {
+			index = len(e.history)
+		}
+	}
+
+	query := []rune(s.query)
+	current := string(e.buf)
+	for index >= 0 && index < len(e.history) {
+		cmd := []rune(e.history[index])
+		p := findRunes(cmd, query, pos, s.forward)
+		// Skip the duplicates of the line shown when looking for the next match.
+		if p >= 0 && (grow || index == from.index || e.history[index] != current) {
+			s.at, s.failed = searchAt{index: index, pos: p}, false
+			e.histIndex = index
+			e.setLine(cmd, p)
+			return
+		}
+		if s.forward {
+			index, pos = index+1, 0
+		} else {
+			index, pos = index-1, -1
+		}
+	}
+	s.failed = true
+}
+
+// findRunes returns the position of the last occurrence of query in text
+// starting at or before pos (backward), or of the first one at or after pos
+// (forward). A negative pos searches backward from the end. It returns -1 if
+// there is none.
+func findRunes(text, query []rune, pos int, forward bool) int {
+	last := len(text) - len(query)
+	if forward {
+		for i := max(pos, 0); i <= last; i++ {
+			if slices.Equal(text[i:i+len(query)], query) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	if pos < 0 || pos > last {
+		pos = last
+	}
+	for i := pos; i >= 0; i-- {
+		if slices.Equal(text[i:i+len(query)], query) {
+			return i
+		}
+	}
+	return -1
+}
+
+// endSearch ends the incremental search, keeping the line found.
+func (e *Editor) endSearch() {
+	if e.search.query != "" {
+		e.lastSearch = e.search.query
+	}
+	e.search = nil
+	e.last = "search"
+}
+
+// searchPrompt returns the prompt shown during an incremental search, with
+// the query, in place of the shell prompt.
+func (e *Editor) searchPrompt() string {
+	s := e.search
+	kind := "reverse-i-search"
+	if s.forward {
+		kind = "i-search"
+	}
+	if s.failed {
+		kind = "failed " + kind
+	}
+	return fmt.Sprintf("(%s)`%s': ", kind, s.query)
+}
@@ -0,0 +1,150 @@
+package lineedit
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aliskhannn/minishell/internal/term"
+)
+
+// Names of the keys that are not single characters. Control characters are
+// named "C-a" to "C-z", and characters typed with Alt (or after Escape) are
+// prefixed with "M-", e.g. "M-b".
+const (
+	keyEnter     = "enter"
+	keyTab       = "tab"
+	keyBackspace = "backspace"
+	keyEscape    = "esc"
+	keyUp        = "up"
+	keyDown      = "down"
+	keyLeft      = "left"
+	keyRight     = "right"
+	keyHome      = "home"
+	keyEnd       = "end"
+	keyDelete    = "delete"
+	keyInsert    = "insert"
+	keyPageUp    = "pgup"
+	keyPageDown  = "pgdown"
+	keyCtrlLeft  = "C-left"
+	keyCtrlRight = "C-right"
+	keyUndo      = "C-_"
+	keyUnknown   = "unknown"
+)
+
+// escapeTimeout is how long to wait, in milliseconds, for the rest of an
+// escape sequence before taking ESC as a key of its own.
+const escapeTimeout = 50
+
+// csiKeys maps the final bytes and parameters of "ESC [" and "ESC O"
+// sequences to key names.
+var csiKeys = map[string]string{
+	"A": keyUp, "B": keyDown, "C": keyRight, "D": keyLeft, "H": keyHome, "F": keyEnd,
+	"1~": keyHome, "7~": keyHome, "4~": keyEnd, "8~": keyEnd, "2~": keyInsert, "3~": keyDelete,
+	"5~": keyPageUp, "6~": keyPageDown,
+	"1;5C": keyCtrlRight, "1;5D": keyCtrlLeft, "1;3C": "M-f", "1;3D": "M-b",
+	"5C": keyCtrlRight, "5D": keyCtrlLeft,
+}
+
+// ctrlKey names the key typed with Ctrl for a control character, e.g. "C-a".
+func ctrlKey(b byte) string {
+	return "C-" + strings.ToLower(string(rune('@'+b)))
+}
+
+// readByte reads a single byte from the terminal. Input is read unbuffered,
+// so that nothing typed ahead is lost for the commands run afterwards.
+func (e *Editor) readByte() (byte, error) {
+	var b [1]byte
+	for {
+		n, err := e.in.Read(b[:])
+		if n == 1 {
+			return b[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// pending reports whether more input arrives within the escape timeout.
+func (e *Editor) pending() bool {
+	ready, err := term.WaitInput(e.fd, escapeTimeout)
+	return err == nil && ready
+}
+
+// readKey reads one key press: a character, a control key or an escape
+// sequence, see the key names above.
+func (e *Editor) readKey() (string, error) {
+	b, err := e.readByte()
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case b == 0x1b:
+		return e.readEscape()
+	case b == '\r' || b == '\n':
+		return keyEnter, nil
+	case b == '\t':
+		return keyTab, nil
+	case b == 0x7f || b == 0x08:
+		return keyBackspace, nil
+	case b < 0x20:
+		return ctrlKey(b), nil
+	case b < utf8.RuneSelf:
+		return string(rune(b)), nil
+	}
+
+	// Collect the continuation bytes of a UTF-8 character.
+	buf := []byte{b}
+	for !utf8.FullRune(buf) && len(buf) < utf8.UTFMax {
+		c, err := e.readByte()
+		if err != nil {
+			return "", err
+		}
+		buf = append(buf, c)
+	}
+	r, _ := utf8.DecodeRune(buf)
+	return string(r), nil
+}
+
+// readEscape reads the rest of a key starting with ESC: a CSI or SS3
+// sequence (arrows, Home, Delete...), an Alt-modified key, or ESC itself.
+func (e *Editor) readEscape() (string, error) {
+	if !e.pending() {
+		return keyEscape, nil
+	}
+
+	b, err := e.readByte()
+	if err != nil {
+		return "", err
+	}
+
+	switch b {
+	case '[', 'O':
+		// Parameters and intermediate bytes, up to the final byte.
+		var seq []byte
+		for {
+			c, err := e.readByte()
+			if err != nil {
+				return "", err
+			}
+			seq = append(seq, c)
+			if c >= 0x40 && c <= 0x7E || len(seq) > 16 {
+				break
+			}
+		}
+		if k, ok := csiKeys[string(seq)]; ok {
+			return k, nil
+		}
+		return keyUnknown, nil
+	case 0x7f, 0x08:
+		return "M-" + keyBackspace, nil
+	case 0x1b:
+		return keyEscape, nil
+	}
+
+	if b < 0x20 {
+		return "M-" + ctrlKey(b), nil
+	}
+	return "M-" + string(rune(b)), nil
+}
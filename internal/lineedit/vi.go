@@ -0,0 +1,470 @@
+package lineedit
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Mode indicators shown before the prompt in vi mode, like readline's
+// vi-ins-mode-string and vi-cmd-mode-string.
+const (
+	viInsertIndicator = "(ins) "
+	viNormalIndicator = "(cmd) "
+)
+
+// viChange is the last command that changed the line in normal mode, with
+// the text typed if it entered insert mode, for the "." command.
+type viChange struct {
+	count int
+	keys  []string
+}
+
+// SetViMode selects vi (true) or Emacs (false) key bindings for the next lines.
+func (e *Editor) SetViMode(on bool) {
+	e.vi = on
+}
+
+// modeIndicator returns the text shown before the prompt for the current mode.
+func (e *Editor) modeIndicator() string {
+	switch {
+	case !e.vi:
+		return ""
+	case e.normal:
+		return viNormalIndicator
+	default:
+		return viInsertIndicator
+	}
+}
+
+// key returns the next key, replaying the keys of a "." command first. Keys
+// typed while a change is recorded are added to it.
+func (e *Editor) key() (string, error) {
+	if len(e.replay) > 0 {
+		k := e.replay[0]
+		e.replay = e.replay[1:]
+		return k, nil
+	}
+
+	var k string
+	if e.split != "" {
+		k, e.split = e.split, ""
+	} else {
+		var err error
+		if k, err = e.nextKey(); err != nil {
+			return "", err
+		}
+
+		// In vi mode there are no Alt bindings: a key typed quickly after
+		// Escape is a key of its own.
+		if e.vi && strings.HasPrefix(k, "M-") {
+			k, e.split = keyEscape, strings.TrimPrefix(k, "M-")
+		}
+	}
+
+	if e.recording {
+		e.recorded = append(e.recorded, k)
+	}
+	return k, nil
+}
+
+// viInsertKey handles a key in vi insert mode. Escape returns to normal mode;
+// the other keys edit the line like in Emacs mode.
+func (e *Editor) viInsertKey(k string) (string, bool, error) {
+	if k != keyEscape {
+		return e.handleKey(k)
+	}
+
+	// The insertion completes the change being recorded.
+	if e.recording {
+		e.lastChange = &viChange{count: e.changeCount, keys: e.recorded}
+		e.recording = false
+	}
+
+	e.normal = true
+	e.pos = max(e.pos-1, 0)
+	e.last = k
+	e.refresh()
+	return "", false, nil
+}
+
+// viCount reads a count typed before a command. It returns the count (0 if
+// none was typed) and the key of the command.
+func (e *Editor) viCount(k string) (int, string, error) {
+	count := 0
+	for len(k) == 1 && k[0] >= '0' && k[0] <= '9' && (k != "0" || count > 0) {
+		count = count*10 + int(k[0]-'0')
+		var err error
+		if k, err = e.key(); err != nil {
+			return 0, "", err
+		}
+	}
+	return count, k, nil
+}
+
+// viNormalKey handles a command in vi normal mode, starting with key k.
+func (e *Editor) viNormalKey(k string) (string, bool, error) {
+	switch k {
+	case keyEnter, "C-c", "C-d", "C-l":
+		return e.handleKey(k)
+	}
+
+	count, k, err := e.viCount(k)
+	if err != nil {
+		return "", false, err
+	}
+
+	if k == "." {
+		if e.lastChange == nil {
+			return "", false, nil
+		}
+		if count == 0 {
+			count = e.lastChange.count
+		}
+		k, e.replay = e.lastChange.keys[0], append([]string(nil), e.lastChange.keys[1:]...)
+	} else {
+		// Record the command, in case it changes the line.
+		e.recording, e.recorded, e.changeCount = true, []string{k}, count
+	}
+
+	changed, insert := e.viCommand(k, count)
+	if insert {
+		// The change is complete when insert mode is left.
+		e.normal = false
+	} else {
+		if changed && e.recording {
+			e.lastChange = &viChange{count: count, keys: e.recorded}
+		}
+		e.recording = false
+		if n := len(e.buf); e.pos >= n {
+			e.pos = max(n-1, 0)
+		}
+	}
+
+	e.last = k
+	e.refresh()
+	return "", false, nil
+}
+
+// viCommand performs a normal mode command. It reports whether the line was
+// changed and whether the command entered insert mode.
+func (e *Editor) viCommand(k string, count int) (changed, insert bool) {
+	n := max(count, 1)
+
+	switch k {
+	// Entering insert mode.
+	case "i", keyInsert:
+		return false, true
+	case "a":
+		e.pos = min(e.pos+1, len(e.buf))
+		return false, true
+	case "I":
+		e.pos = e.firstNonBlank()
+		return false, true
+	case "A":
+		e.pos = len(e.buf)
+		return false, true
+
+	// Shortcuts for the operators.
+	case "x", keyDelete:
+		e.viDelete(e.pos, e.pos+n)
+		return true, false
+	case "X":
+		e.viDelete(e.pos-n, e.pos)
+		return true, false
+	case "s":
+		e.viDelete(e.pos, e.pos+n)
+		return true, true
+	case "S":
+		e.viDelete(0, len(e.buf))
+		return true, true
+	case "D":
+		e.viDelete(e.pos, len(e.buf))
+		return true, false
+	case "C":
+		e.viDelete(e.pos, len(e.buf))
+		return true, true
+	case "Y":
+		e.pushKill(string(e.buf))
+		return false, false
+	case "d", "c", "y":
+		return e.viOperator(k, n)
+
+	// Other changes.
+	case "p", "P":
+		if len(e.kills) == 0 {
+			return false, false
+		}
+		if k == "p" && len(e.buf) > 0 {
+			e.pos++
+		}
+		text := []rune(strings.Repeat(e.kills[len(e.kills)-1], n))
+		e.insert(text)
+		e.pos--
+		return true, false
+	case "r":
+		c, err := e.key()
+		r := []rune(c)
+		if err != nil || len(r) != 1 || !unicode.IsPrint(r[0]) || e.pos+n > len(e.buf) {
+			return false, false
+		}
+		e.save("replace")
+		for i := e.pos; i < e.pos+n; i++ {
+			e.buf[i] = r[0]
+		}
+		e.pos += n - 1
+		return true, false
+	case "~":
+		if len(e.buf) == 0 {
+			return false, false
+		}
+		e.save("case")
+		end := min(e.pos+n, len(e.buf))
+		for i := e.pos; i < end; i++ {
+			if unicode.IsUpper(e.buf[i]) {
+				e.buf[i] = unicode.ToLower(e.buf[i])
+			} else {
+				e.buf[i] = unicode.ToUpper(e.buf[i])
+			}
+		}
+		e.pos = end
+		return true, false
+	case "u", keyUndo:
+		e.undoLast()
+		return false, false
+
+	// History.
+	case "k", "-", keyUp, "j", "+", keyDown:
+		dir := 1
+		if k == "k" || k == "-" || k == keyUp {
+			dir = -1
+		}
+		for range n {
+			e.historyMove(dir)
+			e.last = k
+		}
+		e.pos = 0
+		return false, false
+	case "C-r", "C-s":
+		e.startSearch(k == "C-s")
+		return false, false
+	}
+
+	if pos, _, ok := e.viMotion(k, n); ok {
+		e.pos = pos
+	}
+	return false, false
+}
+
+// viOperator performs "d", "c" or "y" followed by a motion, or doubled
+// ("dd") for the whole line.
+func (e *Editor) viOperator(op string, n int) (changed, insert bool) {
+	k, err := e.key()
+	if err != nil {
+		return false, false
+	}
+	count, k, err := e.viCount(k)
+	if err != nil {
+		return false, false
+	}
+	n *= max(count, 1)
+
+	start, end := 0, len(e.buf)
+	if k != op {
+		// Like in vi, "cw" changes to the end of the word only.
+		if op == "c" && (k == "w" || k == "W") && e.pos < len(e.buf) && !unicode.IsSpace(e.buf[e.pos]) {
+			k = map[string]string{"w": "e", "W": "E"}[k]
+		}
+
+		target, inclusive, ok := e.viMotion(k, n)
+		if !ok {
+			return false, false
+		}
+		start, end = min(e.pos, target), max(e.pos, target)
+		if inclusive {
+			end = min(end+1, len(e.buf))
+		}
+	}
+
+	switch op {
+	case "y":
+		e.pushKill(string(e.buf[start:end]))
+		e.pos = start
+		return false, false
+	case "d":
+		e.viDelete(start, end)
+		return true, false
+	default:
+		e.viDelete(start, end)
+		return true, true
+	}
+}
+
+// viDelete deletes buf[start:end] into the kill ring, like vi's unnamed register.
+func (e *Editor) viDelete(start, end int) {
+	if text := e.deleteRange(start, end); len(text) > 0 {
+		e.pushKill(string(text))
+	}
+}
+
+// pushKill adds text to the kill ring as a new entry.
+func (e *Editor) pushKill(text string) {
+	e.kills = append(e.kills, text)
+	if len(e.kills) > killRingSize {
+		e.kills = e.kills[1:]
+	}
+}
+
+// viMotion returns the position a motion moves the cursor to, repeated n
+// times, and whether an operator applied to it includes the character at
+// that position. ok is false for keys that are not motions.
+func (e *Editor) viMotion(k string, n int) (pos int, inclusive, ok bool) {
+	pos = e.pos
+	switch k {
+	case "h", keyLeft, keyBackspace:
+		return max(pos-n, 0), false, true
+	case "l", keyRight, " ":
+		return min(pos+n, len(e.buf)), false, true
+	case "0", keyHome:
+		return 0, false, true
+	case "^":
+		return e.firstNonBlank(), false, true
+	case "$", keyEnd:
+		return len(e.buf), false, true
+	case "|":
+		return min(n-1, len(e.buf)), false, true
+	case "w", "W", "b", "B", "e", "E":
+		big := k == "W" || k == "B" || k == "E"
+		for range n {
+			switch k {
+			case "w", "W":
+				pos = e.nextWordStart(pos, big)
+			case "b", "B":
+				pos = e.prevWordStart(pos, big)
+			default:
+				pos = e.wordEndPos(pos, big)
+			}
+		}
+		return pos, k == "e" || k == "E", true
+	case "f", "F", "t", "T":
+		c, err := e.key()
+		r := []rune(c)
+		if err != nil || len(r) != 1 {
+			return pos, false, false
+		}
+		e.lastFind, e.lastFindRune = k, r[0]
+		return e.findChar(k, r[0], n, false)
+	case ";", ",":
+		if e.lastFind == "" {
+			return pos, false, false
+		}
+		kind := e.lastFind
+		if k == "," {
+			// Search in the opposite direction.
+			kind = map[string]string{"f": "F", "F": "f", "t": "T", "T": "t"}[kind]
+		}
+		return e.findChar(kind, e.lastFindRune, n, true)
+	}
+	return pos, false, false
+}
+
+// findChar finds the n-th occurrence of r after (f, t) or before (F, T) the
+// cursor. t and T stop next to it. When repeating a t or T search, an
+// occurrence right next to the cursor is skipped, so that the cursor moves.
+func (e *Editor) findChar(kind string, r rune, n int, repeat bool) (int, bool, bool) {
+	pos := e.pos
+	forward := kind == "f" || kind == "t"
+	till := kind == "t" || kind == "T"
+
+	step := 1
+	if !forward {
+		step = -1
+	}
+	if till && repeat {
+		pos += step
+	}
+
+	for found := 0; found < n; {
+		pos += step
+		if pos < 0 || pos >= len(e.buf) {
+			return e.pos, false, false
+		}
+		if e.buf[pos] == r {
+			found++
+		}
+	}
+
+	if till {
+		pos -= step
+	}
+	return pos, forward, true
+}
+
+// firstNonBlank returns the position of the first non-blank character.
+func (e *Editor) firstNonBlank() int {
+	pos := 0
+	for pos < len(e.buf) && unicode.IsSpace(e.buf[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// runeClass classifies characters for the vi word motions: 0 for blanks,
+// 1 for word characters and 2 for punctuation. With big words (W, B, E)
+// everything that is not blank is in the same class.
+func runeClass(r rune, big bool) int {
+	switch {
+	case unicode.IsSpace(r):
+		return 0
+	case big || isWordRune(r):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// nextWordStart returns the start of the next word (w, W).
+func (e *Editor) nextWordStart(pos int, big bool) int {
+	if pos >= len(e.buf) {
+		return len(e.buf)
+	}
+	if c := runeClass(e.buf[pos], big); c != 0 {
+		for pos < len(e.buf) && runeClass(e.buf[pos], big) == c {
+			pos++
+		}
+	}
+	for pos < len(e.buf) && runeClass(e.buf[pos], big) == 0 {
+		pos++
+	}
+	return pos
+}
+
+// prevWordStart returns the start of the current or previous word (b, B).
+func (e *Editor) prevWordStart(pos int, big bool) int {
+	for pos > 0 && runeClass(e.buf[pos-1], big) == 0 {
+		pos--
+	}
+	if pos == 0 {
+		return 0
+	}
+	c := runeClass(e.buf[pos-1], big)
+	for pos > 0 && runeClass(e.buf[pos-1], big) == c {
+		pos--
+	}
+	return pos
+}
+
+// wordEndPos returns the end of the current or next word (e, E).
+func (e *Editor) wordEndPos(pos int, big bool) int {
+	pos++
+	for pos < len(e.buf) && runeClass(e.buf[pos], big) == 0 {
+		pos++
+	}
+	if pos >= len(e.buf) {
+		return max(len(e.buf)-1, 0)
+	}
+	c := runeClass(e.buf[pos], big)
+	for pos+1 < len(e.buf) && runeClass(e.buf[pos+1], big) == c {
+		pos++
+	}
+	return pos
+}
@@ -0,0 +1,71 @@
+package lineedit
+
+import (
+	"unicode"
+)
+
+// wideRanges lists the code points that terminals display in two columns:
+// East Asian wide and full-width characters and most emoji.
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F}, {0x231A, 0x231B}, {0x2329, 0x232A}, {0x23E9, 0x23EC}, {0x23F0, 0x23F0},
+	{0x23F3, 0x23F3}, {0x25FD, 0x25FE}, {0x2614, 0x2615}, {0x2648, 0x2653}, {0x267F, 0x267F},
+	{0x2693, 0x2693}, {0x26A1, 0x26A1}, {0x26AA, 0x26AB}, {0x26BD, 0x26BE}, {0x26C4, 0x26C5},
+	{0x26CE, 0x26CE}, {0x26D4, 0x26D4}, {0x26EA, 0x26EA}, {0x26F2, 0x26F3}, {0x26F5, 0x26F5},
+	{0x26FA, 0x26FA}, {0x26FD, 0x26FD}, {0x2705, 0x2705}, {0x270A, 0x270B}, {0x2728, 0x2728},
+	{0x274C, 0x274C}, {0x274E, 0x274E}, {0x2753, 0x2755}, {0x2757, 0x2757}, {0x2795, 0x2797},
+	{0x27B0, 0x27B0}, {0x27BF, 0x27BF}, {0x2B1B, 0x2B1C}, {0x2B50, 0x2B50}, {0x2B55, 0x2B55},
+	{0x2E80, 0x303E}, {0x3041, 0x33FF}, {0x3400, 0x4DBF}, {0x4E00, 0x9FFF}, {0xA000, 0xA4CF},
+	{0xA960, 0xA97F}, {0xAC00, 0xD7A3}, {0xF900, 0xFAFF}, {0xFE10, 0xFE19}, {0xFE30, 0xFE6F},
+	{0xFF00, 0xFF60}, {0xFFE0, 0xFFE6}, {0x16FE0, 0x18CFF}, {0x1B000, 0x1B2FF}, {0x1F004, 0x1F004},
+	{0x1F0CF, 0x1F0CF}, {0x1F18E, 0x1F18E}, {0x1F191, 0x1F19A}, {0x1F200, 0x1F2FF}, {0x1F300, 0x1F64F},
+	{0x1F680, 0x1F6FF}, {0x1F7E0, 0x1F7EB}, {0x1F90C, 0x1F9FF}, {0x1FA70, 0x1FAFF}, {0x20000, 0x3FFFD},
+}
+
+// runeWidth returns the number of terminal columns used by r: 0 for combining
+// and other zero-width characters, 2 for wide characters and 1 otherwise.
+func runeWidth(r rune) int {
+	switch {
+	case r == 0 || r == 0x200B || unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case r < 0x1100:
+		return 1
+	}
+
+	for _, rg := range wideRanges {
+		if r < rg[0] {
+			break
+		}
+		if r <= rg[1] {
+			return 2
+		}
+	}
+	return 1
+}
+
+// visibleRunes returns the runes of s that take up space on the screen,
+// skipping ANSI escape sequences (e.g. the colors of the prompt).
+func visibleRunes(s string) []rune {
+	var out []rune
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		if rs[i] != '\x1b' {
+			out = append(out, rs[i])
+			continue
+		}
+
+		// Skip "ESC [ params final" (CSI) or "ESC ] ... BEL" (OSC, e.g. titles).
+		if i+1 < len(rs) && rs[i+1] == '[' {
+			i += 2
+			for i < len(rs) && (rs[i] < 0x40 || rs[i] > 0x7E) {
+				i++
+			}
+		} else if i+1 < len(rs) && rs[i+1] == ']' {
+			for i < len(rs) && rs[i] != '\a' {
+				i++
+			}
+		} else {
+			i++
+		}
+	}
+	return out
+}
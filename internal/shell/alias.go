@@ -0,0 +1,92 @@
+package shell
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// builtinAlias implements "alias [name[=value]...]". Without arguments it
+// lists all aliases, with "name" it prints that alias, and with "name=value"
+// it defines one. Aliases are printed as "alias name='value'", so the output
+// can be read back by the shell.
+func (s *Shell) builtinAlias(args []string, st *stdio) error {
+	if len(args) == 0 || (len(args) == 1 && args[0] == "-p") {
+		names := make([]string, 0, len(s.aliases))
+		for name := range s.aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			s.printAlias(name, st)
+		}
+		return nil
+	}
+
+	var failed bool
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+
+		if !hasValue {
+			if _, ok := s.aliases[name]; !ok {
+				_, _ = fmt.Fprintf(st.err, "shell: alias: %s: not found\n", name)
+				failed = true
+				continue
+			}
+			s.printAlias(name, st)
+			continue
+		}
+
+		if !validAliasName(name) {
+			_, _ = fmt.Fprintf(st.err, "shell: alias: `%s': invalid alias name\n", name)
+			failed = true
+			continue
+		}
+		s.aliases[name] = value
+	}
+
+	if failed {
+		return &StatusError{Code: 1}
+	}
+	return nil
+}
+
+// printAlias prints an alias in re-usable form.
+func (s *Shell) printAlias(name string, st *stdio) {
+	value := strings.ReplaceAll(s.aliases[name], "'", `'\''`)
+	_, _ = fmt.Fprintf(st.out, "alias %s='%s'\n", name, value)
+}
+
+// validAliasName reports whether name can be used as an alias: it must be a
+// non-empty word without quotes, expansions, slashes or shell metacharacters.
+func validAliasName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\n'\"\\$`/=|&;()<>")
+}
+
+// builtinUnalias implements "unalias name..." and "unalias -a", which removes all aliases.
+func (s *Shell) builtinUnalias(args []string, st *stdio) error {
+	if len(args) == 0 {
+		return fmt.Errorf("unalias: usage: unalias [-a] name [name ...]")
+	}
+
+	if args[0] == "-a" {
+		clear(s.aliases)
+		return nil
+	}
+
+	var failed bool
+	for _, name := range args {
+		if _, ok := s.aliases[name]; !ok {
+			_, _ = fmt.Fprintf(st.err, "shell: unalias: %s: not found\n", name)
+			failed = true
+			continue
+		}
+		delete(s.aliases, name)
+	}
+
+	if failed {
+		return &StatusError{Code: 1}
+	}
+	return nil
+}
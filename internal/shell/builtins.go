@@ -1,26 +1,55 @@
 package shell
 
-import (
-	"fmt"
-)
+// builtinFunc is the signature shared by all builtin commands.
+// args excludes the command name; st holds the streams the command runs with.
+type builtinFunc func(s *Shell, args []string, st *stdio) error
 
-func RunCommand(c *Command) error {
-	if c == nil || c.Name == "" {
-		return nil
-	}
+// builtins maps builtin command names to their implementations.
+// It is filled in init to allow builtins that refer back to the table.
+var builtins map[string]builtinFunc
 
-	switch c.Name {
-	case "cd":
-		return builtinCD(c.Args)
-	case "pwd":
-		return buildinPWD()
-	case "echo":
-		return builtinEcho(c.Args, c.Output)
-	case "ps":
-		return builtinPs()
-	case "kill":
-		return builtinKill(c.Args)
-	default:
-		return fmt.Errorf("unknown builtin %q", c.Name)
+func init() {
+	builtins = map[string]builtinFunc{
+		"cd":       (*Shell).builtinCD,
+		"pwd":      (*Shell).buildinPWD,
+		"pushd":    (*Shell).builtinPushd,
+		"popd":     (*Shell).builtinPopd,
+		"dirs":     (*Shell).builtinDirs,
+		"z":        (*Shell).builtinZ,
+		"echo":     (*Shell).builtinEcho,
+		"printf":   (*Shell).builtinPrintf,
+		"ps":       (*Shell).builtinPs,
+		"top":      (*Shell).builtinTop,
+		"pgrep":    (*Shell).builtinPgrep,
+		"pkill":    (*Shell).builtinPkill,
+		"kill":     (*Shell).builtinKill,
+		"break":    (*Shell).builtinBreak,
+		"continue": (*Shell).builtinContinue,
+		"true":     (*Shell).builtinTrue,
+		":":        (*Shell).builtinTrue,
+		"false":    (*Shell).builtinFalse,
+		"local":    (*Shell).builtinLocal,
+		"return":   (*Shell).builtinReturn,
+		"source":   (*Shell).builtinSource,
+		".":        (*Shell).builtinSource,
+		"export":   (*Shell).builtinExport,
+		"unset":    (*Shell).builtinUnset,
+		"set":      (*Shell).builtinSet,
+		"history":  (*Shell).builtinHistory,
+		"alias":    (*Shell).builtinAlias,
+		"unalias":  (*Shell).builtinUnalias,
+		"complete": (*Shell).builtinComplete,
+		"test":     (*Shell).builtinTest,
+		"[":        (*Shell).builtinBracket,
 	}
 }
+
+// builtinTrue implements "true" and ":", which do nothing and succeed.
+func (s *Shell) builtinTrue(_ []string, _ *stdio) error {
+	return nil
+}
+
+// builtinFalse implements "false", which does nothing and fails.
+func (s *Shell) builtinFalse(_ []string, _ *stdio) error {
+	return &StatusError{Code: 1}
+}
@@ -3,14 +3,35 @@ package shell
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"syscall"
 )
 
 var ErrTooManyArguments = fmt.Errorf("too many arguments")
 
-// BuiltinCD implements the "cd" command for changing directories.
+// BuiltinCD implements the "cd [-L|-P] [dir]" command for changing directories.
+// By default the path is followed logically: ".." removes the last component of
+// $PWD, so leaving a directory entered through a symbolic link returns to the
+// link's parent. With -P symbolic links are resolved first. Relative paths are
+// searched in $CDPATH. PWD and OLDPWD are updated after a successful change,
+// and the new directory is recorded in the frecency database of z.
 // Returns an error if more than one argument is provided or if the directory change fails.
-func builtinCD(args []string) error {
+func (s *Shell) builtinCD(args []string, st *stdio) error {
+	physical := false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		if strings.Trim(args[0][1:], "LP") != "" {
+			return fmt.Errorf("cd: %s: invalid option", args[0])
+		}
+		// The last of -L and -P wins.
+		physical = args[0][len(args[0])-1] == 'P'
+		args = args[1:]
+	}
+
 	if len(args) > 1 {
 		return ErrTooManyArguments
 	}
@@ -22,107 +43,172 @@ func builtinCD(args []string) error {
 		path = args[0]
 	}
 
-	// If the path is "-", change to the previous directory.
-	if path == "-" {
-		return chdirToPrevious()
-	}
-
-	return chdir(path)
-}
-
-// chdir changes the current working directory to the specified path.
-func chdir(path string) error {
-	// If the path is empty or just "~", change to the home directory.
-	if path == "" || path == "~" {
-		return chdirToHome()
-	}
-
-	// Get the current working directory to set OLDPWD.
-	cwd, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("cd: cannot get current directory: %w", err)
-	}
-
-	// Set OLDPWD to the current working directory before changing.
-	_ = os.Setenv("OLDPWD", cwd)
+	// show is set when the new directory is not the one that was typed:
+	// "cd -" and directories found in CDPATH are printed like in bash.
+	show := false
 
-	// If the path starts with "~/", replace it with the user's home directory.
-	if strings.HasPrefix(path, "~/") {
-		home, err := os.UserHomeDir() // get the user's home directory
+	switch {
+	case path == "" || path == "~":
+		// If the path is empty or just "~", change to the home directory.
+		home, err := s.homeDir()
 		if err != nil {
 			return fmt.Errorf("cd: cannot get user home: %s", err)
 		}
-
-		path = strings.Replace(path, "~", home, 1) // replace "~" with home directory
+		path = home
+	case path == "-":
+		// If the path is "-", change to the previous directory.
+		prev, ok := s.LookupVar("OLDPWD")
+		if !ok {
+			return fmt.Errorf("cd: OLDPWD not set")
+		}
+		path, show = prev, true
+	case strings.HasPrefix(path, "~/"):
+		// If the path starts with "~/", replace it with the user's home directory.
+		home, err := s.homeDir()
+		if err != nil {
+			return fmt.Errorf("cd: cannot get user home: %s", err)
+		}
+		path = home + path[1:]
+	default:
+		if dir, ok := s.searchCDPath(path); ok {
+			path, show = dir, true
+		}
 	}
 
-	// Change to the specified directory.
-	if err := changeDir(path); err != nil {
+	dir, err := s.chdir(path, physical)
+	if err != nil {
 		return fmt.Errorf("cd: %w", err)
 	}
+	s.recordDir(dir)
+
+	if show {
+		_, _ = fmt.Fprintln(st.out, dir)
+	}
 
 	return nil
 }
 
-// chdirToPrevious changes the current working directory to the previous directory stored in OLDPWD.
-func chdirToPrevious() error {
-	// Get the previous directory from the OLDPWD environment variable.
-	prevDir, ok := os.LookupEnv("OLDPWD")
-	if !ok {
-		return fmt.Errorf("cd: OLDPWD not set")
+// chdir changes the current working directory to path and updates PWD and
+// OLDPWD. It returns the new working directory. A relative path is resolved
+// against the logical working directory, unless physical is set or the
+// logical path does not lead anywhere (e.g. ".." of a removed link).
+func (s *Shell) chdir(path string, physical bool) (string, error) {
+	// Get the current working directory to set OLDPWD. If it has been
+	// removed, the last known path still allows "cd .." to work.
+	cwd, err := s.WorkingDir()
+	if err != nil && cwd == "" {
+		return "", fmt.Errorf("cannot get current directory: %w", err)
 	}
 
-	// Get the current working directory to set OLDPWD.
-	cwd, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("cd: cannot get current directory: %w", err)
+	if !physical {
+		logical := path
+		if !filepath.IsAbs(logical) {
+			logical = filepath.Join(cwd, logical)
+		}
+		logical = filepath.Clean(logical)
+
+		if s.changeDir(logical) == nil {
+			s.setDirVars(cwd, logical)
+			return logical, nil
+		}
 	}
 
-	// Set OLDPWD to the current working directory before changing.
-	_ = os.Setenv("OLDPWD", cwd)
+	// Change to the specified directory, resolving symbolic links.
+	if err := s.changeDir(path); err != nil {
+		return "", err
+	}
 
-	// Change to the previous directory.
-	if err := changeDir(prevDir); err != nil {
-		return fmt.Errorf("cd: %w", err)
+	dir, err := s.physicalDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot get current directory: %w", err)
 	}
+	if s.dir != "" {
+		s.dir = dir
+	}
+	s.setDirVars(cwd, dir)
 
-	// Print the previous directory to stdout like the shell does.
-	fmt.Println(prevDir)
+	return dir, nil
+}
 
-	return nil
+// setDirVars exports OLDPWD and PWD after a directory change, so the prompt
+// and child processes see the logical path.
+func (s *Shell) setDirVars(old, cur string) {
+	s.setEnv("OLDPWD", old)
+	s.setEnv("PWD", cur)
+	delete(s.vars, "OLDPWD")
+	delete(s.vars, "PWD")
 }
 
-// chdirToHome changes the current working directory to the user's home directory.
-func chdirToHome() error {
-	// Get the current working directory to set OLDPWD.
-	cwd, err := os.Getwd()
-	if err != nil {
-		return fmt.Errorf("cd: cannot get current directory: %w", err)
+// searchCDPath looks up a relative directory in the colon-separated
+// directories of $CDPATH. Paths starting with "/", "." or ".." are never
+// searched. An empty CDPATH entry stands for the current directory; the
+// returned ok is only true for matches in other entries, which cd prints.
+func (s *Shell) searchCDPath(path string) (string, bool) {
+	cdpath := s.Var("CDPATH")
+	if cdpath == "" || filepath.IsAbs(path) || path == "." || path == ".." ||
+		strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
+		return "", false
 	}
 
-	// Set OLDPWD to the current working directory before changing.
-	_ = os.Setenv("OLDPWD", cwd)
+	for _, base := range strings.Split(cdpath, ":") {
+		if base == "" || base == "." {
+			if info, err := os.Stat(s.path(path)); err == nil && info.IsDir() {
+				return "", false
+			}
+			continue
+		}
 
-	// Get the user's home directory.
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return fmt.Errorf("cd: cannot get user home: %s", err)
+		dir := filepath.Join(base, path)
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir, true
+		}
 	}
 
-	// Change to the home directory.
-	if err := changeDir(home); err != nil {
-		return fmt.Errorf("cd: %w", err)
-	}
+	return "", false
+}
 
-	return nil
+// homeDir returns $HOME, or the user's home directory if it is not set.
+func (s *Shell) homeDir() (string, error) {
+	if home := s.Var("HOME"); home != "" {
+		return home, nil
+	}
+	return os.UserHomeDir()
 }
 
 // changeDir attempts to change the current working directory to the specified path.
-func changeDir(path string) error {
-	if err := os.Chdir(path); err != nil {
+// A pipeline stage only changes its own, see enterDir.
+func (s *Shell) changeDir(path string) error {
+	var err error
+	if s.dir != "" {
+		err = s.enterDir(path)
+	} else {
+		err = os.Chdir(path)
+	}
+	if err != nil {
 		msg := strings.Replace(err.Error(), "chdir ", "", 1) // Remove "chdir" prefix if present
 		return fmt.Errorf("%s", msg)
 	}
 
 	return nil
 }
+
+// enterDir makes path the working directory of a pipeline stage, if the
+// process could change to it: it must be a directory that may be searched.
+func (s *Shell) enterDir(path string) error {
+	dir := s.path(path)
+
+	var st syscall.Stat_t
+	err := syscall.Stat(dir, &st)
+	if err == nil && st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		err = syscall.ENOTDIR
+	}
+	if err == nil {
+		err = syscall.Access(dir, 1) // search permission
+	}
+	if err != nil {
+		return &os.PathError{Op: "chdir", Path: path, Err: err}
+	}
+
+	s.dir = filepath.Clean(dir)
+	return nil
+}
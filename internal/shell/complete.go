@@ -0,0 +1,324 @@
+package shell
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Completion is a candidate for completing the word before the cursor.
+type Completion struct {
+	Text    string // text replacing the word, quoted as needed
+	Display string // text shown when the candidates are listed
+	NoSpace bool   // no space is added after the word, e.g. for directories
+}
+
+// commandWords are the reserved words after which a new command starts.
+var commandWords = []string{"if", "then", "else", "elif", "while", "until", "do", "!", "{"}
+
+// specialChars are the characters escaped with a backslash in completed
+// words, so that they are taken literally.
+const specialChars = " \t\n\\'\"`$;&|<>()*?[]#!{}"
+
+// compWord is the word being completed, as found by scanWord.
+type compWord struct {
+	start   int      // position of the word in the line, in runes
+	raw     string   // text of the word up to the cursor, as typed
+	value   string   // the same text with quotes and escapes removed
+	quote   rune     // quote open at the cursor, or 0
+	command bool     // the word is in command position
+	words   []string // previous words of the command, from its name, unquoted
+}
+
+// Complete returns the candidates for completing the word of line that
+// ends at pos (in runes), and the position where that word starts. In command
+// position, words complete to command names: builtins, functions, aliases and
+// the programs of PATH. The arguments of commands with a spec registered by
+// complete are completed by that spec. Elsewhere words complete to file names,
+// keeping their quotes or escaping special characters. "$NAME" completes to
+// variable names, "~NAME" to user names, and "%" would complete job
+// specifications.
+func (s *Shell) Complete(line string, pos int) (int, []Completion) {
+	w := scanWord([]rune(line)[:pos])
+
+	if !w.command && len(w.words) > 0 {
+		if spec, ok := s.compSpecFor(w.words[0]); ok {
+			return w.start, s.completeSpec(spec, w, line, pos)
+		}
+	}
+
+	// A variable name after "$" or "${", anywhere but in single quotes.
+	if i := strings.LastIndex(w.raw, "$"); i >= 0 && w.quote != '\'' {
+		name := strings.TrimPrefix(w.raw[i+1:], "{")
+		if !strings.ContainsFunc(name, func(r rune) bool { return !isAlnum(r) && r != '_' }) {
+			braced := strings.HasPrefix(w.raw[i+1:], "{")
+			return w.start + len([]rune(w.raw[:i])), s.completeVars(name, braced, w.quote != 0)
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(w.raw, "%"):
+		// Commands always run in the foreground, so there are no jobs.
+		return w.start, nil
+	case strings.HasPrefix(w.raw, "~") && !strings.Contains(w.raw, "/"):
+		return w.start, completeUsers(w.raw[1:])
+	case w.command && !strings.Contains(w.value, "/"):
+		return w.start, s.completeCommands(w)
+	default:
+		return w.start, s.completePaths(w, w.command)
+	}
+}
+
+// scanWord finds the word that ends at the end of text, which is the line up
+// to the cursor, and whether it is in command position.
+func scanWord(text []rune) compWord {
+	w := compWord{command: true}
+	var value strings.Builder
+	redirect := false
+
+	// endWord is called at the end of each word before the cursor.
+	endWord := func(i int) {
+		if i > w.start {
+			word := value.String()
+			switch {
+			case redirect:
+				redirect = false
+			case w.command && slices.Contains(commandWords, word):
+			case w.command && strings.Contains(word, "=") && assignName(word) != "":
+				// Assignments may precede the command name.
+			default:
+				w.command = false
+				w.words = append(w.words, word)
+			}
+		}
+		w.start = i + 1
+		value.Reset()
+	}
+
+	for i := 0; i < len(text); i++ {
+		r := text[i]
+		switch {
+		case w.quote == '\'':
+			if r == '\'' {
+				w.quote = 0
+			} else {
+				value.WriteRune(r)
+			}
+		case r == '\\' && i+1 < len(text):
+			i++
+			value.WriteRune(text[i])
+		case w.quote == '"':
+			if r == '"' {
+				w.quote = 0
+			} else {
+				value.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			w.quote = r
+		case r == ' ' || r == '\t':
+			endWord(i)
+		case strings.ContainsRune(";&|()\n", r):
+			endWord(i)
+			w.command, w.words, redirect = true, nil, false
+		case r == '<' || r == '>':
+			endWord(i)
+			redirect = true
+		default:
+			value.WriteRune(r)
+		}
+	}
+
+	w.raw, w.value = string(text[w.start:]), value.String()
+	return w
+}
+
+// completeVars returns the variables whose names start with prefix.
+func (s *Shell) completeVars(prefix string, braced, quoted bool) []Completion {
+	var names []string
+	add := func(name string) {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	for _, kv := range s.environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		add(name)
+	}
+	for name := range s.vars {
+		add(name)
+	}
+	for name := range s.arrays {
+		add(name)
+	}
+	for _, sc := range s.scopes {
+		for name := range sc {
+			add(name)
+		}
+	}
+	slices.Sort(names)
+
+	var list []Completion
+	for _, name := range slices.Compact(names) {
+		c := Completion{Text: "$" + name, Display: name, NoSpace: quoted}
+		if braced {
+			c.Text = "${" + name + "}"
+		}
+		list = append(list, c)
+	}
+	return list
+}
+
+// completeUsers returns "~name/" for the users whose names start with prefix.
+func completeUsers(prefix string) []Completion {
+	f, err := os.Open("/etc/passwd")
+	if err != nil {
+		return nil
+	}
+	defer func() { _ = f.Close() }()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, _, _ := strings.Cut(scanner.Text(), ":")
+		if name != "" && !strings.HasPrefix(name, "#") && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var list []Completion
+	for _, name := range slices.Compact(names) {
+		list = append(list, Completion{Text: "~" + name + "/", Display: "~" + name, NoSpace: true})
+	}
+	return list
+}
+
+// completeCommands returns the builtins, functions, aliases and programs of
+// PATH whose names start with the word.
+func (s *Shell) completeCommands(w compWord) []Completion {
+	var names []string
+	add := func(name string) {
+		if strings.HasPrefix(name, w.value) {
+			names = append(names, name)
+		}
+	}
+	for name := range builtins {
+		add(name)
+	}
+	for name := range s.funcs {
+		add(name)
+	}
+	for name := range s.aliases {
+		add(name)
+	}
+	for _, dir := range filepath.SplitList(s.Var("PATH")) {
+		if dir == "" {
+			dir = "."
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), w.value) && isExecutable(filepath.Join(dir, e.Name())) {
+				names = append(names, e.Name())
+			}
+		}
+	}
+	slices.Sort(names)
+
+	var list []Completion
+	for _, name := range slices.Compact(names) {
+		list = append(list, Completion{Text: quoteWord(name, w.quote, true), Display: name})
+	}
+	return list
+}
+
+// completePaths returns the files whose paths start with the word. Only
+// directories and executable files are returned if programs is set, for a
+// command name containing a slash.
+func (s *Shell) completePaths(w compWord, programs bool) []Completion {
+	dirPart, base := "", w.value
+	if i := strings.LastIndex(w.value, "/"); i >= 0 {
+		dirPart, base = w.value[:i+1], w.value[i+1:]
+	}
+
+	// Expand a leading tilde to read the directory, but keep it in the word.
+	dir := dirPart
+	if strings.HasPrefix(w.raw, "~") {
+		prefix, rest, _ := strings.Cut(dirPart[1:], "/")
+		if home, ok := s.expandTilde(prefix); ok {
+			dir = home + "/" + rest
+		}
+	}
+	if dir == "" {
+		dir = "."
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var list []Completion
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, base) || name[0] == '.' && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if isDir(path) {
+			list = append(list, Completion{Text: quoteWord(dirPart+name+"/", w.quote, false), Display: name + "/", NoSpace: true})
+			continue
+		}
+		if programs && !isExecutable(path) {
+			continue
+		}
+		list = append(list, Completion{Text: quoteWord(dirPart+name, w.quote, true), Display: name})
+	}
+	return list
+}
+
+// isExecutable reports whether path is a file that can be executed.
+func isExecutable(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Mode()&0o111 != 0
+}
+
+// quoteWord quotes a completed word: inside the quote the word was started
+// with, closed if close is set, or with a backslash before each special
+// character. A leading tilde is kept, for the tilde expansion.
+func quoteWord(word string, quote rune, close bool) string {
+	var b strings.Builder
+	switch quote {
+	case '\'':
+		b.WriteString("'" + strings.ReplaceAll(word, "'", `'\''`))
+	case '"':
+		b.WriteByte('"')
+		for _, r := range word {
+			if strings.ContainsRune("\\\"$`", r) {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+	default:
+		if strings.HasPrefix(word, "~") {
+			b.WriteByte('~')
+			word = word[1:]
+		}
+		for _, r := range word {
+			if strings.ContainsRune(specialChars, r) {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	}
+
+	if close {
+		b.WriteRune(quote)
+	}
+	return b.String()
+}
@@ -0,0 +1,253 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// loopControl is returned by the break and continue builtins and unwinds
+// the enclosing loops until the targeted one is reached.
+type loopControl struct {
+	brk bool // break rather than continue
+	n   int  // number of enclosing loops still to unwind
+}
+
+func (e *loopControl) Error() string {
+	if e.brk {
+		return "break"
+	}
+	return "continue"
+}
+
+// runCompound executes a compound command.
+func (s *Shell) runCompound(c Compound, st *stdio) error {
+	switch c := c.(type) {
+	case *IfClause:
+		return s.runIf(c, st)
+	case *LoopClause:
+		return s.runLoop(c, st)
+	case *ForClause:
+		return s.runFor(c, st)
+	case *CaseClause:
+		return s.runCase(c, st)
+	case *BraceGroup:
+		return s.runList(c.Body, st)
+	case *CondExpr:
+		return s.runCond(c)
+	case *FuncDef:
+		s.funcs[c.Name] = c.Body
+		return nil
+	default:
+		return fmt.Errorf("unsupported compound command %T", c)
+	}
+}
+
+// runIf executes the body of the first if/elif branch whose condition
+// succeeds, or the else branch. Without a matching branch the status is 0.
+func (s *Shell) runIf(c *IfClause, st *stdio) error {
+	for i, cond := range c.Conds {
+		err := s.runList(cond, st)
+		if isControl(err) {
+			return err
+		}
+		if err == nil {
+			return s.runList(c.Bodies[i], st)
+		}
+	}
+
+	if c.Else != nil {
+		return s.runList(c.Else, st)
+	}
+
+	return nil
+}
+
+// runLoop executes a while or until loop.
+// The result is that of the last body execution, or success if the body never ran.
+func (s *Shell) runLoop(c *LoopClause, st *stdio) error {
+	s.loops++
+	defer func() { s.loops-- }()
+
+	var result error
+	for {
+		if err := s.checkInterrupt(); err != nil {
+			return err
+		}
+
+		err := s.runList(c.Cond, st)
+		if isControl(err) {
+			if stop, err := s.loopExit(err); stop {
+				return err
+			}
+			continue
+		}
+		if (err == nil) == c.Until {
+			return result
+		}
+
+		result = s.runList(c.Body, st)
+		if isControl(result) {
+			if stop, err := s.loopExit(result); stop {
+				return err
+			}
+			result = nil
+		}
+	}
+}
+
+// runFor executes a for loop, assigning each word to the loop variable in turn.
+func (s *Shell) runFor(c *ForClause, st *stdio) error {
+	words := append([]string(nil), s.params...)
+	if !c.Params {
+		var err error
+		if words, err = s.expandWords(c.Words); err != nil {
+			return err
+		}
+	}
+
+	s.loops++
+	defer func() { s.loops-- }()
+
+	var result error
+	for _, w := range words {
+		if err := s.checkInterrupt(); err != nil {
+			return err
+		}
+		s.setVar(c.Name, w)
+
+		result = s.runList(c.Body, st)
+		if isControl(result) {
+			if stop, err := s.loopExit(result); stop {
+				return err
+			}
+			result = nil
+		}
+	}
+
+	return result
+}
+
+// runCase executes the body of the first item with a pattern matching the
+// subject word. Patterns are matched like pathnames, but without touching the
+// filesystem and with '*' matching '/' too. Quoted parts of the patterns only
+// match themselves. The item terminator decides what happens next:
+// ";;" stops, ";&" runs the next body unconditionally and ";;&" keeps testing.
+func (s *Shell) runCase(c *CaseClause, st *stdio) error {
+	word, err := s.expandString(c.Word)
+	if err != nil {
+		return err
+	}
+
+	var result error
+	fall := false
+	for _, item := range c.Items {
+		if !fall {
+			matched, err := s.caseMatch(item, word)
+			if err != nil {
+				return err
+			}
+			if !matched {
+				continue
+			}
+		}
+
+		result = s.runList(item.Body, st)
+		if isControl(result) {
+			return result
+		}
+
+		switch item.Term {
+		case ";&":
+			fall = true
+		case ";;&":
+			fall = false
+		default:
+			return result
+		}
+	}
+
+	return result
+}
+
+// caseMatch reports whether any pattern of the case item matches word.
+func (s *Shell) caseMatch(item *CaseItem, word string) (bool, error) {
+	for _, raw := range item.Patterns {
+		pat, err := s.expandPattern(raw)
+		if err != nil {
+			return false, err
+		}
+		if matchPattern(pat, word, false) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isFlow reports whether err is a control flow request: break, continue or return.
+func isFlow(err error) bool {
+	var ctl *loopControl
+	var ret *funcReturn
+	return errors.As(err, &ctl) || errors.As(err, &ret)
+}
+
+// isControl reports whether err interrupts the normal flow of a list:
+// break, continue, return or Ctrl+C.
+func isControl(err error) bool {
+	return isFlow(err) || isInterrupt(err)
+}
+
+// loopExit handles a control error raised inside a loop body. It reports
+// whether the loop must stop and, if so, the error to return from it.
+func (s *Shell) loopExit(err error) (bool, error) {
+	var ctl *loopControl
+	if !errors.As(err, &ctl) {
+		// Ctrl+C and return stop all loops.
+		return true, err
+	}
+
+	if ctl.n > 1 {
+		// break/continue N: unwind the outer loops as well.
+		return true, &loopControl{brk: ctl.brk, n: ctl.n - 1}
+	}
+
+	return ctl.brk, nil
+}
+
+// builtinBreak implements "break [n]": exit from n enclosing loops.
+func (s *Shell) builtinBreak(args []string, st *stdio) error {
+	return s.loopControl("break", true, args, st)
+}
+
+// builtinContinue implements "continue [n]": resume the n-th enclosing loop.
+func (s *Shell) builtinContinue(args []string, st *stdio) error {
+	return s.loopControl("continue", false, args, st)
+}
+
+// loopControl validates the arguments of break/continue and builds the control error.
+func (s *Shell) loopControl(name string, brk bool, args []string, st *stdio) error {
+	if len(args) > 1 {
+		return fmt.Errorf("%s: %w", name, ErrTooManyArguments)
+	}
+
+	n := 1
+	if len(args) == 1 {
+		var err error
+		n, err = strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("%s: %s: numeric argument required", name, args[0])
+		}
+		if n < 1 {
+			return fmt.Errorf("%s: %s: loop count out of range", name, args[0])
+		}
+	}
+
+	if s.loops == 0 {
+		// Outside of a loop, break and continue only print a warning.
+		_, _ = fmt.Fprintf(st.err, "shell: %s: only meaningful in a `for', `while', or `until' loop\n", name)
+		return nil
+	}
+
+	// Breaking out of more loops than exist stops at the outermost one.
+	return &loopControl{brk: brk, n: min(n, s.loops)}
+}
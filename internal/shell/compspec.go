@@ -0,0 +1,261 @@
+package shell
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// compSpec is a completion specification registered with complete: how the
+// arguments of a command are completed.
+type compSpec struct {
+	words    string // -W: word list, expanded and split when completing
+	function string // -F: function that fills COMPREPLY
+	command  string // -C: program that prints the candidates
+	files    bool   // -o default: complete file names if nothing else matches
+	noSpace  bool   // -o nospace: no space is added after a unique completion
+}
+
+// String returns the spec as a complete command that defines it again.
+func (c *compSpec) String(name string) string {
+	var b strings.Builder
+	b.WriteString("complete")
+	if c.files {
+		b.WriteString(" -o default")
+	}
+	if c.noSpace {
+		b.WriteString(" -o nospace")
+	}
+	if c.words != "" {
+		b.WriteString(" -W " + quote(c.words))
+	}
+	if c.function != "" {
+		b.WriteString(" -F " + quote(c.function))
+	}
+	if c.command != "" {
+		b.WriteString(" -C " + quote(c.command))
+	}
+	return b.String() + " " + quote(name)
+}
+
+// builtinComplete implements "complete [-o option] [-W wordlist] [-F function]
+// [-C command] name...", which sets how the arguments of the named commands
+// are completed with Tab:
+//
+//	-W wordlist  the words of wordlist, expanded and split at blanks, that
+//	             start with the word being completed
+//	-F function  the elements of the COMPREPLY array set by the function
+//	-C command   the lines printed by the command
+//
+// Functions and commands get the command name, the word being completed and
+// the word before it as arguments. Functions also see the words of the
+// command in the COMP_WORDS array and the index of the word being completed
+// in COMP_CWORD. Both get the line in COMP_LINE and the cursor position in
+// COMP_POINT. Their candidates are not filtered: they return the ones that
+// fit. "-o default" completes file names when there are no candidates, and
+// "-o nospace" adds no space after a unique completion.
+//
+// "complete -p [name...]" (or complete without options) prints the specs in
+// a form that can be read back, and "complete -r [name...]" removes them, or
+// all of them.
+func (s *Shell) builtinComplete(args []string, st *stdio) error {
+	spec := &compSpec{}
+	mode := ""
+	set := false
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-" {
+		opt := args[0]
+		args = args[1:]
+		if opt == "--" {
+			break
+		}
+
+		switch opt {
+		case "-p", "-r":
+			mode = opt
+			continue
+		case "-W", "-F", "-C", "-o":
+		default:
+			return fmt.Errorf("complete: %s: invalid option", opt)
+		}
+
+		if len(args) == 0 {
+			return fmt.Errorf("complete: %s: option requires an argument", opt)
+		}
+		value := args[0]
+		args = args[1:]
+		set = true
+		switch opt {
+		case "-W":
+			spec.words = value
+		case "-F":
+			spec.function = value
+		case "-C":
+			spec.command = value
+		case "-o":
+			switch value {
+			case "default":
+				spec.files = true
+			case "nospace":
+				spec.noSpace = true
+			default:
+				return fmt.Errorf("complete: %s: invalid option name", value)
+			}
+		}
+	}
+
+	switch {
+	case mode == "-r" && len(args) == 0:
+		clear(s.compSpecs)
+		return nil
+	case mode == "" && set:
+		if len(args) == 0 {
+			return fmt.Errorf("complete: usage: complete [-p|-r] [-o option] [-W wordlist] [-F function] [-C command] [name ...]")
+		}
+		for _, name := range args {
+			s.compSpecs[name] = spec
+		}
+		return nil
+	case len(args) == 0:
+		names := make([]string, 0, len(s.compSpecs))
+		for name := range s.compSpecs {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		args = names
+	}
+
+	var failed bool
+	for _, name := range args {
+		spec, ok := s.compSpecs[name]
+		if !ok {
+			_, _ = fmt.Fprintf(st.err, "shell: complete: %s: no completion specification\n", name)
+			failed = true
+			continue
+		}
+		if mode == "-r" {
+			delete(s.compSpecs, name)
+			continue
+		}
+		if st.broken() {
+			return errBrokenPipe
+		}
+		_, _ = fmt.Fprintln(st.out, spec.String(name))
+	}
+
+	if failed {
+		return &StatusError{Code: 1}
+	}
+	return nil
+}
+
+// compSpecFor returns the completion spec of the command name, registered
+// either for the name as typed or for its last path element.
+func (s *Shell) compSpecFor(name string) (*compSpec, bool) {
+	if spec, ok := s.compSpecs[name]; ok {
+		return spec, true
+	}
+	spec, ok := s.compSpecs[filepath.Base(name)]
+	return spec, ok
+}
+
+// completeSpec returns the candidates of a completion spec for the word w,
+// an argument of the command w.words[0]. line and pos are the whole line and
+// the cursor position, passed on to functions and commands.
+func (s *Shell) completeSpec(spec *compSpec, w compWord, line string, pos int) []Completion {
+	name, cur, prev := w.words[0], w.value, w.words[len(w.words)-1]
+	var words []string
+
+	if spec.words != "" {
+		list, err := s.expandString(spec.words)
+		if err == nil {
+			for _, word := range strings.Fields(list) {
+				if strings.HasPrefix(word, cur) {
+					words = append(words, word)
+				}
+			}
+		}
+	}
+	if spec.function != "" {
+		words = append(words, s.compFunction(spec.function, w, line, pos)...)
+	}
+	if spec.command != "" {
+		words = append(words, compCommand(spec.command, []string{name, cur, prev}, w, line, pos)...)
+	}
+
+	slices.Sort(words)
+	var list []Completion
+	for _, word := range slices.Compact(words) {
+		list = append(list, Completion{Text: quoteWord(word, w.quote, true), Display: word, NoSpace: spec.noSpace})
+	}
+	if len(list) == 0 && spec.files {
+		list = s.completePaths(w, false)
+	}
+	return list
+}
+
+// compFunction calls the completion function fn and returns the elements of
+// COMPREPLY. The COMP_ variables and COMPREPLY are only set during the call.
+func (s *Shell) compFunction(fn string, w compWord, line string, pos int) []string {
+	body, ok := s.funcs[fn]
+	if !ok {
+		_, _ = fmt.Fprintf(os.Stderr, "shell: complete: %s: function not found\n", fn)
+		return nil
+	}
+
+	s.setArray("COMP_WORDS", append(slices.Clone(w.words), w.value))
+	s.setVar("COMP_CWORD", strconv.Itoa(len(w.words)))
+	s.setVar("COMP_LINE", line)
+	s.setVar("COMP_POINT", strconv.Itoa(pos))
+	s.unsetVar("COMPREPLY")
+	status := s.status
+	defer func() {
+		for _, name := range []string{"COMP_WORDS", "COMP_CWORD", "COMP_LINE", "COMP_POINT", "COMPREPLY"} {
+			s.unsetVar(name)
+		}
+		s.status = status
+	}()
+
+	args := []string{w.words[0], w.value, w.words[len(w.words)-1]}
+	st := &stdio{in: strings.NewReader(""), out: io.Discard, err: os.Stderr}
+	_ = s.report(s.callFunction(fn, body, args, st))
+
+	if reply, ok := s.arrays["COMPREPLY"]; ok {
+		return slices.Clone(reply)
+	}
+	if v, ok := s.LookupVar("COMPREPLY"); ok {
+		return []string{v}
+	}
+	return nil
+}
+
+// compCommand runs the completion program command with args and returns the
+// lines it prints. The line, the cursor position and the index of the word
+// are passed in COMP_LINE, COMP_POINT and COMP_CWORD.
+func compCommand(command string, args []string, w compWord, line string, pos int) []string {
+	cmd := exec.Command(command, args...)
+	cmd.Env = append(os.Environ(),
+		"COMP_LINE="+line,
+		"COMP_POINT="+strconv.Itoa(pos),
+		"COMP_CWORD="+strconv.Itoa(len(w.words)))
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil && len(out) == 0 {
+		return nil
+	}
+
+	var words []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if word := scanner.Text(); word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
@@ -0,0 +1,287 @@
+package shell
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// condNode is a node of a [[ ]] expression tree.
+type condNode interface{}
+
+// condAnd is "left && right".
+type condAnd struct{ left, right condNode }
+
+// condOr is "left || right".
+type condOr struct{ left, right condNode }
+
+// condNot is "! expr".
+type condNot struct{ expr condNode }
+
+// condUnary is a unary test such as "-f word".
+type condUnary struct{ op, word string }
+
+// condBinary is a binary test such as "left == right" or "left =~ right".
+type condBinary struct{ op, left, right string }
+
+// condWord is a single word, true if it expands to a non-empty string.
+type condWord struct{ word string }
+
+// parseCond parses "[[ expression ]]".
+func (p *parser) parseCond() (*CondExpr, error) {
+	p.has = false // consume "[["
+
+	expr, err := p.condOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.skipNewlines(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("]]"); err != nil {
+		return nil, err
+	}
+
+	return &CondExpr{Expr: expr}, nil
+}
+
+// condOr parses "expr || expr", the loosest binding operator.
+func (p *parser) condOr() (condNode, error) {
+	left, err := p.condAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok, err := p.condPeek()
+		if err != nil {
+			return nil, err
+		}
+		if !isOp(tok, "||") {
+			return left, nil
+		}
+		p.has = false
+
+		right, err := p.condAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &condOr{left: left, right: right}
+	}
+}
+
+// condAnd parses "expr && expr".
+func (p *parser) condAnd() (condNode, error) {
+	left, err := p.condNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		tok, err := p.condPeek()
+		if err != nil {
+			return nil, err
+		}
+		if !isOp(tok, "&&") {
+			return left, nil
+		}
+		p.has = false
+
+		right, err := p.condNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &condAnd{left: left, right: right}
+	}
+}
+
+// condNot parses "! expr".
+func (p *parser) condNot() (condNode, error) {
+	tok, err := p.condPeek()
+	if err != nil {
+		return nil, err
+	}
+	if isWord(tok, "!") {
+		p.has = false
+		expr, err := p.condNot()
+		if err != nil {
+			return nil, err
+		}
+		return &condNot{expr: expr}, nil
+	}
+
+	return p.condPrimary()
+}
+
+// condPrimary parses a parenthesized expression, a unary or binary test, or a single word.
+func (p *parser) condPrimary() (condNode, error) {
+	tok, err := p.condPeek()
+	if err != nil {
+		return nil, err
+	}
+	p.has = false
+
+	if isOp(tok, "(") {
+		expr, err := p.condOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok, err = p.condPeek(); err != nil {
+			return nil, err
+		}
+		p.has = false
+		if !isOp(tok, ")") {
+			return nil, syntaxError(tok)
+		}
+		return expr, nil
+	}
+
+	if tok.kind != tokWord || tok.val == "]]" {
+		return nil, syntaxError(tok)
+	}
+
+	next, err := p.condPeek()
+	if err != nil {
+		return nil, err
+	}
+
+	// Unary test: "-f word".
+	if unaryTests[tok.val] && next.kind == tokWord && next.val != "]]" && !condBinaryOp(next) {
+		p.has = false
+		return &condUnary{op: tok.val, word: next.val}, nil
+	}
+
+	// Binary test: "word op word".
+	if condBinaryOp(next) {
+		p.has = false
+
+		// The right side of =~ is a regular expression, in which
+		// parentheses and "|" are not shell operators.
+		var right token
+		if next.val == "=~" {
+			right, err = p.lex.regexWord()
+		} else if right, err = p.condPeek(); err == nil {
+			p.has = false
+		}
+		if err != nil {
+			return nil, err
+		}
+		if right.kind != tokWord || right.val == "]]" {
+			return nil, syntaxError(right)
+		}
+		return &condBinary{op: next.val, left: tok.val, right: right.val}, nil
+	}
+
+	return &condWord{word: tok.val}, nil
+}
+
+// condPeek returns the next token of a [[ ]] expression,
+// in which newlines are ignored.
+func (p *parser) condPeek() (token, error) {
+	if err := p.skipNewlines(); err != nil {
+		return token{}, err
+	}
+	return p.peek()
+}
+
+// condBinaryOp reports whether tok is a binary operator of [[ ]].
+func condBinaryOp(tok token) bool {
+	if tok.kind == tokOp {
+		return tok.val == "<" || tok.val == ">"
+	}
+	return tok.kind == tokWord && (binaryTests[tok.val] || tok.val == "=~")
+}
+
+// runCond evaluates a [[ ]] expression: success if true, status 1 if false,
+// status 2 for an invalid expression.
+func (s *Shell) runCond(c *CondExpr) error {
+	ok, err := s.evalCond(c.Expr)
+	if err != nil {
+		return testFailure("[[", err)
+	}
+	if !ok {
+		return &StatusError{Code: 1}
+	}
+	return nil
+}
+
+// evalCond evaluates a node of a [[ ]] expression. && and || short-circuit.
+func (s *Shell) evalCond(n condNode) (bool, error) {
+	switch n := n.(type) {
+	case *condAnd:
+		ok, err := s.evalCond(n.left)
+		if err != nil || !ok {
+			return false, err
+		}
+		return s.evalCond(n.right)
+	case *condOr:
+		ok, err := s.evalCond(n.left)
+		if err != nil || ok {
+			return ok, err
+		}
+		return s.evalCond(n.right)
+	case *condNot:
+		ok, err := s.evalCond(n.expr)
+		return !ok, err
+	case *condWord:
+		v, err := s.expandString(n.word)
+		return v != "", err
+	case *condUnary:
+		v, err := s.expandString(n.word)
+		if err != nil {
+			return false, err
+		}
+		return s.testUnary(n.op, v)
+	case *condBinary:
+		return s.evalCondBinary(n)
+	}
+
+	return false, fmt.Errorf("invalid conditional expression")
+}
+
+// evalCondBinary evaluates a binary test of [[ ]]. The right side of == and !=
+// is a pattern and the right side of =~ an extended regular expression;
+// quoted parts of either only match themselves. A successful =~ match stores
+// the matched string and the submatches in the BASH_REMATCH array.
+func (s *Shell) evalCondBinary(n *condBinary) (bool, error) {
+	left, err := s.expandString(n.left)
+	if err != nil {
+		return false, err
+	}
+
+	switch n.op {
+	case "=", "==", "!=":
+		pat, err := s.expandPattern(n.right)
+		if err != nil {
+			return false, err
+		}
+		return matchPattern(pat, left, false) == (n.op != "!="), nil
+	case "=~":
+		expr, err := s.expandRegexp(n.right)
+		if err != nil {
+			return false, err
+		}
+		// POSIX extended regular expressions match the leftmost-longest
+		// text, as in bash. Perl syntax such as \d is still accepted.
+		re, err := regexp.CompilePOSIX(expr)
+		if err != nil {
+			re, err = regexp.Compile(expr)
+		}
+		if err != nil {
+			return false, &testError{msg: "[[: " + err.Error()}
+		}
+
+		m := re.FindStringSubmatch(left)
+		if m == nil {
+			delete(s.arrays, "BASH_REMATCH")
+			return false, nil
+		}
+		s.arrays["BASH_REMATCH"] = m
+		return true, nil
+	}
+
+	right, err := s.expandString(n.right)
+	if err != nil {
+		return false, err
+	}
+	return s.testBinary(n.op, left, right)
+}
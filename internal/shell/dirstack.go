@@ -0,0 +1,221 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dirStack returns the directory stack as shown by dirs: the current
+// directory followed by the directories saved by pushd.
+func (s *Shell) dirStack() []string {
+	cwd, _ := s.WorkingDir()
+	return append([]string{cwd}, s.dirs...)
+}
+
+// stackIndex converts "+N" (counting from the left of the dirs listing,
+// starting with zero) or "-N" (counting from the right) into an index of a
+// stack of n entries. ok is false if spec is not such an index.
+func stackIndex(spec string, n int) (i int, ok bool, err error) {
+	if len(spec) < 2 || (spec[0] != '+' && spec[0] != '-') {
+		return 0, false, nil
+	}
+	num, convErr := strconv.Atoi(spec[1:])
+	if convErr != nil || num < 0 {
+		return 0, false, nil
+	}
+
+	i = num
+	if spec[0] == '-' {
+		i = n - 1 - num
+	}
+	if i < 0 || i >= n {
+		return 0, true, fmt.Errorf("%s: directory stack index out of range", spec)
+	}
+	return i, true, nil
+}
+
+// builtinDirs implements "dirs [-clpv] [+N | -N]": print the directory stack,
+// with the home directory abbreviated as ~ unless -l is given. -p prints one
+// entry per line, -v also numbers them, and -c clears the stack.
+func (s *Shell) builtinDirs(args []string, st *stdio) error {
+	var long, perLine, verbose bool
+	entry := -1
+
+	for _, arg := range args {
+		stack := s.dirStack()
+		if i, ok, err := stackIndex(arg, len(stack)); ok {
+			if err != nil {
+				return fmt.Errorf("dirs: %w", err)
+			}
+			entry = i
+			continue
+		}
+		if len(arg) < 2 || arg[0] != '-' || strings.Trim(arg[1:], "clpv") != "" {
+			return fmt.Errorf("dirs: %s: invalid option", arg)
+		}
+		for _, r := range arg[1:] {
+			switch r {
+			case 'c':
+				s.dirs = nil
+			case 'l':
+				long = true
+			case 'p':
+				perLine = true
+			case 'v':
+				verbose = true
+			}
+		}
+	}
+
+	stack := s.dirStack()
+	if entry >= 0 {
+		_, _ = fmt.Fprintln(st.out, s.displayDir(stack[entry], long))
+		return nil
+	}
+
+	s.printDirs(st, long, perLine, verbose)
+	return nil
+}
+
+// printDirs prints the directory stack in the formats of dirs.
+func (s *Shell) printDirs(st *stdio, long, perLine, verbose bool) {
+	stack := s.dirStack()
+	if !perLine && !verbose {
+		for i, dir := range stack {
+			stack[i] = s.displayDir(dir, long)
+		}
+		_, _ = fmt.Fprintln(st.out, strings.Join(stack, " "))
+		return
+	}
+
+	for i, dir := range stack {
+		if verbose {
+			_, _ = fmt.Fprintf(st.out, "%2d  %s\n", i, s.displayDir(dir, long))
+		} else {
+			_, _ = fmt.Fprintln(st.out, s.displayDir(dir, long))
+		}
+	}
+}
+
+// displayDir abbreviates the home directory at the start of dir as ~,
+// unless long is set.
+func (s *Shell) displayDir(dir string, long bool) string {
+	home, err := s.homeDir()
+	if long || err != nil || home == "" || home == "/" {
+		return dir
+	}
+	if dir == home {
+		return "~"
+	}
+	if strings.HasPrefix(dir, home+"/") {
+		return "~" + dir[len(home):]
+	}
+	return dir
+}
+
+// builtinPushd implements "pushd [-n] [dir | +N | -N]". With a directory it
+// saves the current directory on the stack and changes to dir. With +N or -N
+// it rotates the stack so that entry N is on top and changes to it. Without
+// arguments it exchanges the two top entries. -n only manipulates the stack.
+// The resulting stack is printed like dirs does.
+func (s *Shell) builtinPushd(args []string, st *stdio) error {
+	noChdir := false
+	if len(args) > 0 && args[0] == "-n" {
+		noChdir, args = true, args[1:]
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("pushd: %w", ErrTooManyArguments)
+	}
+
+	stack := s.dirStack()
+	switch {
+	case len(args) == 0:
+		if len(s.dirs) == 0 {
+			return fmt.Errorf("pushd: no other directory")
+		}
+		if !noChdir {
+			if _, err := s.chdir(s.dirs[0], false); err != nil {
+				return fmt.Errorf("pushd: %w", err)
+			}
+		}
+		s.dirs[0] = stack[0]
+	default:
+		i, ok, err := stackIndex(args[0], len(stack))
+		if err != nil {
+			return fmt.Errorf("pushd: %w", err)
+		}
+		if ok {
+			rotated := append(stack[i:], stack[:i]...)
+			if !noChdir {
+				if _, err := s.chdir(rotated[0], false); err != nil {
+					return fmt.Errorf("pushd: %w", err)
+				}
+			}
+			s.dirs = rotated[1:]
+			break
+		}
+
+		dir := args[0]
+		if noChdir {
+			s.dirs = append([]string{dir}, s.dirs...)
+			break
+		}
+		if _, err := s.chdir(dir, false); err != nil {
+			return fmt.Errorf("pushd: %w", err)
+		}
+		s.dirs = append([]string{stack[0]}, s.dirs...)
+	}
+
+	s.printDirs(st, false, false, false)
+	return nil
+}
+
+// builtinPopd implements "popd [-n] [+N | -N]". Without arguments it removes
+// the top of the stack and changes to the new top directory. +N or -N removes
+// entry N instead; only removing entry 0 changes the directory. -n removes
+// without changing the directory. The resulting stack is printed like dirs does.
+func (s *Shell) builtinPopd(args []string, st *stdio) error {
+	noChdir := false
+	if len(args) > 0 && args[0] == "-n" {
+		noChdir, args = true, args[1:]
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("popd: %w", ErrTooManyArguments)
+	}
+	if len(s.dirs) == 0 {
+		return fmt.Errorf("popd: directory stack empty")
+	}
+
+	stack := s.dirStack()
+	i := 0
+	if len(args) == 1 {
+		var ok bool
+		var err error
+		if i, ok, err = stackIndex(args[0], len(stack)); err != nil {
+			return fmt.Errorf("popd: %w", err)
+		} else if !ok {
+			return fmt.Errorf("popd: %s: invalid argument", args[0])
+		}
+	}
+
+	switch {
+	case i == 0 && noChdir:
+		// Like bash, -n leaves the current directory alone and removes the
+		// first saved directory instead.
+		s.dirs = s.dirs[1:]
+	case i == 0:
+		if _, err := s.chdir(s.dirs[0], false); err != nil {
+			return fmt.Errorf("popd: %w", err)
+		}
+		s.dirs = s.dirs[1:]
+	default:
+		s.dirs = append(s.dirs[:i-1], s.dirs[i:]...)
+	}
+
+	s.printDirs(st, false, false, false)
+	return nil
+}
@@ -1,169 +1,546 @@
 package shell
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 )
 
+// errCommandNotFound is reported when a command is neither a builtin nor found in PATH.
+var errCommandNotFound = errors.New("command not found")
+
+// StatusError reports a non-zero exit status whose cause, if any, has already
+// been reported to the user (e.g. a failed builtin or a negated pipeline).
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// errBrokenPipe stops a builtin or compound command whose output is no longer
+// read, e.g. a loop piped into "head" after head has exited.
+var errBrokenPipe = &StatusError{Code: 141}
+
+// errInterrupted stops the commands of a line after Ctrl+C, like a command
+// killed by SIGINT, even when they are builtins that never see the signal.
+var errInterrupted = &StatusError{Code: 130}
+
+// interrupted records that Ctrl+C was pressed while a line was running.
+var interrupted atomic.Bool
+
+// Interrupt tells the running commands that Ctrl+C was pressed: loops and
+// lists of commands stop with status 130 before their next command. It is
+// safe to call from a signal handler goroutine.
+func Interrupt() {
+	interrupted.Store(true)
+}
+
+// checkInterrupt returns errInterrupted, and sets the status to 130, if
+// Ctrl+C was pressed since the line started.
+func (s *Shell) checkInterrupt() error {
+	if !interrupted.Load() {
+		return nil
+	}
+	s.status = errInterrupted.Code
+	return errInterrupted
+}
+
+// stdio bundles the standard streams a command runs with.
+type stdio struct {
+	in     io.Reader
+	out    io.Writer
+	err    io.Writer
+	closed <-chan struct{} // closed when the reader of out has gone away
+}
+
+// broken reports whether the output has no reader anymore.
+func (st *stdio) broken() bool {
+	select {
+	case <-st.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// exitCode converts the result of a command into its exit status.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitErr.ExitCode()
+	}
+
+	if errors.Is(err, errCommandNotFound) {
+		return 127
+	}
+
+	// Errors may carry their own status, e.g. 2 for a malformed test expression.
+	var coder interface{ ExitCode() int }
+	if errors.As(err, &coder) {
+		return coder.ExitCode()
+	}
+
+	var ret *funcReturn
+	if errors.As(err, &ret) {
+		return ret.code
+	}
+
+	var ctl *loopControl
+	if errors.As(err, &ctl) {
+		return 0
+	}
+
+	return 1
+}
+
+// isStatus reports whether err only carries an exit status, with nothing left to report.
+func isStatus(err error) bool {
+	var statusErr *StatusError
+	var exitErr *exec.ExitError
+	return errors.As(err, &statusErr) || errors.As(err, &exitErr)
+}
+
+// isInterrupt reports whether err means a command was killed by Ctrl+C or
+// lost the reader of its output, in which case the rest of the input should
+// not be executed.
+func isInterrupt(err error) bool {
+	if errors.Is(err, errBrokenPipe) || errors.Is(err, errInterrupted) {
+		return true
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.Signaled() && status.Signal() == syscall.SIGINT
+		}
+	}
+	return false
+}
+
+// report prints a command's error message to stderr and turns it into a
+// plain exit status. Exit statuses and control flow (break, continue, return)
+// are returned unchanged.
+func (s *Shell) report(err error) error {
+	if err == nil || isStatus(err) || isFlow(err) {
+		return err
+	}
+
+	// Errors from a sourced file mention where they happened.
+	if s.script != "" {
+		_, _ = fmt.Fprintf(os.Stderr, "shell: %s:%d: %v\n", s.script, s.lineno, err)
+	} else {
+		_, _ = fmt.Fprintln(os.Stderr, "shell:", err)
+	}
+
+	return &StatusError{Code: exitCode(err)}
+}
+
+// runList executes the pipelines of a list one after another and returns the
+// result of the last one. Execution stops early on break/continue or Ctrl+C.
+func (s *Shell) runList(l *List, st *stdio) error {
+	var err error
+
+	for _, p := range l.Items {
+		if st.broken() {
+			return errBrokenPipe
+		}
+		if err := s.checkInterrupt(); err != nil {
+			return err
+		}
+
+		err = s.Run(p, st)
+
+		if isControl(err) {
+			return err
+		}
+	}
+
+	return err
+}
+
 // Run executes a pipeline and handles logical operators (&& and ||).
-// - If a pipeline fails and there is an OR branch (`||`), it continues there.
-// - If a pipeline succeeds and there is an AND branch (`&&`), it continues there.
-// Returns the last encountered error, or nil if all succeeded.
-func (s *Shell) Run(p *Pipeline) error {
+// - If a pipeline fails, the following && pipelines are skipped up to the next || branch.
+// - If a pipeline succeeds, the following || pipelines are skipped up to the next && branch.
+// Returns the result of the last executed pipeline.
+func (s *Shell) Run(p *Pipeline, st *stdio) error {
 	cur := p
 	var lastErr error
 
 	for cur != nil {
-		err := s.RunPipeline(cur)
-		if err != nil {
-			lastErr = err
+		s.lineno = cur.Line
+		lastErr = s.report(s.RunPipeline(cur, st))
+		s.status = exitCode(lastErr)
 
-			// If command failed but we have an "OR" pipeline, go there.
-			if cur.OrNext != nil {
-				cur = cur.OrNext
-				continue
-			}
-			break
-		} else {
-			lastErr = nil
-
-			// If command succeeded and we have an "AND" pipeline, go there.
-			if cur.AndNext != nil {
-				cur = cur.AndNext
-				continue
-			}
-			break
+		if isControl(lastErr) {
+			return lastErr
+		}
+
+		// Skip pipelines that do not apply: after a failure, && branches are
+		// skipped up to the next || one, and after a success the other way around.
+		failed := lastErr != nil
+		for cur != nil && next(cur, failed) == nil {
+			cur = next(cur, !failed)
+		}
+		if cur != nil {
+			cur = next(cur, failed)
 		}
 	}
 
 	return lastErr
 }
 
-// RunPipeline executes a single pipeline (possibly multiple commands connected with pipes).
-// It sets up pipes between processes, redirects input/output, and manages signal handling.
-// If the command is a builtin and the pipeline has only one command, it is executed directly.
-func (s *Shell) RunPipeline(p *Pipeline) error {
-	var cmds []*exec.Cmd
-	var prevStdout *os.File
-	var files []*os.File // open files for redirection
-	var pipes []*os.File // pipes between commands
-
-	// Listen for SIGINT (Ctrl+C) so we can forward it to child processes.
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT)
-	defer signal.Stop(sigCh)
+// next returns the branch of p taken for the given result.
+func next(p *Pipeline, failed bool) *Pipeline {
+	if failed {
+		return p.OrNext
+	}
+	return p.AndNext
+}
 
-	var currentCmds []*exec.Cmd
+// RunPipeline executes a single pipeline (possibly multiple commands connected with pipes).
+// Every command of a multi-command pipeline runs concurrently: external commands as
+// processes and builtins or compound commands in goroutines, connected by OS pipes.
+// The result is that of the last command, inverted if the pipeline is negated.
+func (s *Shell) RunPipeline(p *Pipeline, st *stdio) error {
+	var err error
+	if len(p.Commands) == 1 {
+		err = s.runCommand(p.Commands[0], st)
+	} else {
+		err = s.runStages(p.Commands, st)
+	}
 
-	for i, c := range p.Commands {
-		// If it's a builtin and the only command in a pipeline: run directly
-		if IsBuiltin(c.Name) && len(p.Commands) == 1 {
-			return RunCommand(c)
+	if p.Negate {
+		if isFlow(err) {
+			return err
 		}
+		if s.report(err) == nil {
+			return &StatusError{Code: 1}
+		}
+		return nil
+	}
 
-		// Create an external command process.
-		cmd := exec.Command(c.Name, c.Args...)
-		cmd.Stderr = os.Stderr
-		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} // put command in its own process group
+	return err
+}
+
+// runStages runs the commands of a pipeline concurrently, connecting the
+// output of each one to the input of the next. Each command runs in a
+// subshell, so that builtins and functions neither share the state of the
+// shell nor change it; the working directory and the environment, which
+// belong to the process, are restored when the pipeline ends.
+func (s *Shell) runStages(cmds []*Command, st *stdio) error {
+	errs := make([]error, len(cmds))
+	var wg sync.WaitGroup
+
+	in := st.in
+	var readerDone chan struct{} // closed when the current command's input reader exits
+	for i, c := range cmds {
+		stage := &stdio{in: in, out: st.out, err: st.err, closed: st.closed}
 
-		// --- Setup stdin ---
+		// Create a pipe for communication with the next command.
+		var r, w *os.File
+		var done chan struct{}
+		if i < len(cmds)-1 {
+			var err error
+			r, w, err = os.Pipe()
+			if err != nil {
+				wg.Wait()
+				return err
+			}
+			done = make(chan struct{})
+			stage.out, stage.closed = w, done
+		}
+
+		prev, _ := in.(*os.File)
 		if i == 0 {
-			// The first command may have input redirection.
-			if c.Input != "" {
-				inFile, err := os.Open(c.Input)
-				if err != nil {
-					return err
-				}
-				cmd.Stdin = inFile
-				files = append(files, inFile)
+			prev = nil // never close the pipeline's own input
+		}
+
+		wg.Add(1)
+		go func(i int, sub *Shell, c *Command, stage *stdio, w, prev *os.File, prevDone chan struct{}) {
+			defer wg.Done()
+
+			errs[i] = sub.runCommand(c, stage)
+
+			// Close our pipe ends so that neighbours see EOF or EPIPE,
+			// and tell the previous command that nobody reads its output anymore.
+			if w != nil {
+				_ = w.Close()
 			}
-		} else {
-			// Subsequent command gets stdin from previous pipe.
-			cmd.Stdin = prevStdout
-		}
-
-		// --- Setup stdout ---
-		if i == len(p.Commands)-1 {
-			// Last command may redirect output to file.
-			if c.Output != "" {
-				outFile, err := os.Create(c.Output)
-				if err != nil {
-					return err
-				}
-				cmd.Stdout = outFile
-				files = append(files, outFile)
-			} else {
-				cmd.Stdout = os.Stdout
+			if prev != nil {
+				_ = prev.Close()
+			}
+			if prevDone != nil {
+				close(prevDone)
 			}
-		} else {
-			// Create a pipe for communication with the next command.
-			r, w, err := os.Pipe()
+		}(i, s.subshell(), c, stage, w, prev, readerDone)
+
+		in, readerDone = r, done
+	}
+
+	wg.Wait()
+
+	// Report failures of all but the last command, whose result is the pipeline's.
+	for _, err := range errs[:len(errs)-1] {
+		if !isStatus(err) {
+			_ = s.report(err)
+		}
+	}
+
+	return errs[len(errs)-1]
+}
+
+// runCommand executes a single command of a pipeline: a compound command,
+// an assignment, a builtin or an external program.
+func (s *Shell) runCommand(c *Command, st *stdio) error {
+	// Set up redirections first; they apply to every kind of command.
+	st, closeFiles, err := s.redirect(c, st)
+	if err != nil {
+		return err
+	}
+	defer closeFiles()
+
+	if c.Compound != nil {
+		return s.runCompound(c.Compound, st)
+	}
+
+	argv, err := s.expandWords(append([]string{c.Name}, c.Args...))
+	if err != nil {
+		return err
+	}
+
+	// Without a command, assignments change shell variables.
+	if len(argv) == 0 {
+		for _, a := range c.Assigns {
+			if err := s.assign(a); err != nil {
+				return err
+			}
+		}
+		for _, a := range c.Arrays {
+			words, err := s.expandWords(a.Words)
 			if err != nil {
 				return err
 			}
-			cmd.Stdout = w
-			prevStdout = r
-			pipes = append(pipes, w, r)
+			s.setArray(a.Name, words)
+		}
+		return nil
+	}
+	if len(c.Arrays) > 0 {
+		return fmt.Errorf("%s: arrays cannot be assigned for a command", c.Arrays[0].Name)
+	}
+
+	// Functions take precedence over builtins and external commands.
+	if fn, ok := s.funcs[argv[0]]; ok {
+		restore, err := s.tempAssign(c.Assigns)
+		if err != nil {
+			return err
 		}
+		defer restore()
 
-		cmds = append(cmds, cmd)
-		currentCmds = append(currentCmds, cmd)
+		return s.callFunction(argv[0], fn, argv[1:], st)
 	}
 
-	// Start all commands.
-	for _, cmd := range cmds {
-		if err := cmd.Start(); err != nil {
+	if fn, ok := builtins[argv[0]]; ok {
+		// Assignments before a builtin only last for the command.
+		restore, err := s.tempAssign(c.Assigns)
+		if err != nil {
 			return err
 		}
+		defer restore()
+
+		return fn(s, argv[1:], st)
+	}
+
+	return s.runExternal(argv, c.Assigns, st)
+}
+
+// tempAssign performs assignments that only last until the returned function is called.
+func (s *Shell) tempAssign(assigns []string) (func(), error) {
+	type saved struct {
+		name, value string
+		ok          bool
+	}
+	var prev []saved
+
+	restore := func() {
+		for i := len(prev) - 1; i >= 0; i-- {
+			if prev[i].ok {
+				s.setVar(prev[i].name, prev[i].value)
+			} else {
+				delete(s.vars, prev[i].name)
+			}
+		}
+	}
+
+	for _, a := range assigns {
+		name := assignName(a)
+		value, ok := s.LookupVar(name)
+		prev = append(prev, saved{name: name, value: value, ok: ok})
+
+		if err := s.assign(a); err != nil {
+			restore()
+			return nil, err
+		}
+	}
+
+	return restore, nil
+}
+
+// assignName returns the variable name of a "NAME=value" word.
+func assignName(word string) string {
+	for i, r := range word {
+		if r == '=' {
+			return word[:i]
+		}
 	}
+	return word
+}
 
-	// Close all pipe file descriptors in a parent process.
-	for _, f := range pipes {
-		_ = f.Close()
+// runExternal starts an external program, waits for it to finish and forwards
+// Ctrl+C to it while it runs.
+func (s *Shell) runExternal(argv, assigns []string, st *stdio) error {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	if s.env != nil && !strings.Contains(argv[0], "/") {
+		// A pipeline stage looks programs up in its own PATH.
+		cmd.Path, cmd.Err = s.lookPath(argv[0])
+	}
+	if errors.Is(cmd.Err, exec.ErrNotFound) {
+		return fmt.Errorf("%s: %w", argv[0], errCommandNotFound)
 	}
-	// Close redirection files.
-	for _, f := range files {
-		_ = f.Close()
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = st.in, st.out, st.err
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} // put command in its own process group
+
+	// A pipeline stage passes on its own working directory and environment.
+	cmd.Dir = s.dir
+	if s.env != nil {
+		cmd.Env = s.environ()
 	}
-	// Ensure last read end of pipe is closed eventually.
-	if prevStdout != nil {
-		defer func() { _ = prevStdout.Close() }()
+
+	// Assignments before the command only go to its environment.
+	if len(assigns) > 0 {
+		cmd.Env = s.environ()
+		for _, a := range assigns {
+			name := assignName(a)
+			value, err := s.expandString(a[len(name)+1:])
+			if err != nil {
+				return err
+			}
+			cmd.Env = append(cmd.Env, name+"="+value)
+		}
+	}
+
+	// Listen for SIGINT (Ctrl+C) so we can forward it to the child process.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT)
+	defer signal.Stop(sigCh)
+
+	if err := cmd.Start(); err != nil {
+		return err
 	}
 
 	// Goroutine to handle SIGINT (Ctrl+C).
 	done := make(chan struct{})
 	go func() {
-		for sig := range sigCh {
-			if sig == syscall.SIGINT {
-				if len(currentCmds) > 0 {
-					for _, c := range currentCmds {
-						if c.Process != nil {
-							pgid, _ := syscall.Getpgid(c.Process.Pid)
-							_ = syscall.Kill(-pgid, syscall.SIGINT)
-						}
-					}
-				}
+		for range sigCh {
+			pgid, err := syscall.Getpgid(cmd.Process.Pid)
+			if err == nil {
+				_ = syscall.Kill(-pgid, syscall.SIGINT)
 			}
 		}
 		close(done)
 	}()
 
-	// Wait for all commands in pipeline to finish.
-	var err error
-	for _, cmd := range cmds {
-		if werr := cmd.Wait(); werr != nil {
-			// Preserve the first error if multiple fail.
-			if err == nil {
-				err = werr
-			}
-		}
-	}
+	err := cmd.Wait()
 
 	// Stop listening for signals and wait for goroutine to exit.
+	signal.Stop(sigCh)
 	close(sigCh)
 	<-done
 
 	return err
 }
+
+// lookPath searches the PATH of a pipeline stage for an executable file
+// called name. Relative directories in PATH are resolved against the
+// stage's working directory.
+func (s *Shell) lookPath(name string) (string, error) {
+	path, _ := s.lookupEnv("PATH")
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		file := s.path(filepath.Join(dir, name))
+		if info, err := os.Stat(file); err == nil && info.Mode().IsRegular() && info.Mode()&0o111 != 0 {
+			return file, nil
+		}
+	}
+	return "", &exec.Error{Name: name, Err: exec.ErrNotFound}
+}
+
+// redirect applies a command's input and output redirections on top of st.
+// The returned function closes the opened files.
+func (s *Shell) redirect(c *Command, st *stdio) (*stdio, func(), error) {
+	var files []*os.File
+	closeFiles := func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}
+
+	if c.Input == "" && c.Output == "" {
+		return st, closeFiles, nil
+	}
+	out := *st
+
+	if c.Input != "" {
+		name, err := s.expandString(c.Input)
+		if err != nil {
+			return nil, nil, err
+		}
+		f, err := os.Open(s.path(name))
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, f)
+		out.in = f
+	}
+
+	if c.Output != "" {
+		name, err := s.expandString(c.Output)
+		if err != nil {
+			closeFiles()
+			return nil, nil, err
+		}
+		f, err := os.Create(s.path(name))
+		if err != nil {
+			closeFiles()
+			return nil, nil, err
+		}
+		files = append(files, f)
+		out.out = f
+	}
+
+	return &out, closeFiles, nil
+}
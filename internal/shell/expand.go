@@ -0,0 +1,601 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// field is a word being built during expansion. pat mirrors value, except
+// that quoted pattern characters are escaped with a backslash, so that
+// pathname expansion only treats unquoted *, ? and [ as special. re does the
+// same for regular expressions, as used by "[[ word =~ regexp ]]".
+type field struct {
+	value  strings.Builder
+	pat    strings.Builder
+	re     strings.Builder
+	glob   bool // contains unquoted pattern characters
+	quoted bool // contains a quoted part, so it is kept even when empty
+}
+
+// expander performs tilde, parameter and quote expansion of a single word,
+// optionally followed by field splitting.
+type expander struct {
+	s      *Shell
+	split  bool     // split unquoted expansion results on IFS
+	fields []*field // fields produced so far
+	brk    bool     // the next character starts a new field
+}
+
+// expandWords expands command words into the final argument list: tilde and
+// parameter expansion, field splitting, pathname expansion and quote removal.
+func (s *Shell) expandWords(words []string) ([]string, error) {
+	var out []string
+
+	for _, w := range words {
+		e := &expander{s: s, split: true}
+		if err := e.expand(w); err != nil {
+			return nil, err
+		}
+
+		for _, f := range e.fields {
+			if f.value.Len() == 0 && !f.quoted {
+				continue
+			}
+
+			if f.glob {
+				if matches := s.globPaths(f.pat.String()); matches != nil {
+					out = append(out, matches...)
+					continue
+				}
+			}
+			out = append(out, f.value.String())
+		}
+	}
+
+	return out, nil
+}
+
+// expandString expands a word into a single string without field splitting
+// or pathname expansion, as done for assignments and redirection targets.
+func (s *Shell) expandString(word string) (string, error) {
+	e := &expander{s: s}
+	if err := e.expand(word); err != nil {
+		return "", err
+	}
+
+	var parts []string
+	for _, f := range e.fields {
+		parts = append(parts, f.value.String())
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+// cur returns the field currently being built, starting a new one if needed.
+func (e *expander) cur() *field {
+	if len(e.fields) == 0 || e.brk {
+		e.fields = append(e.fields, &field{})
+		e.brk = false
+	}
+	return e.fields[len(e.fields)-1]
+}
+
+// add appends literal text to the current field.
+func (e *expander) add(text string, quoted bool) {
+	f := e.cur()
+	f.value.WriteString(text)
+	if quoted {
+		f.quoted = true
+		f.re.WriteString(regexp.QuoteMeta(text))
+	} else {
+		f.re.WriteString(text)
+	}
+
+	for _, r := range text {
+		if strings.ContainsRune(`*?[]\`, r) {
+			if quoted {
+				f.pat.WriteByte('\\')
+			} else if r != ']' && r != '\\' {
+				f.glob = true
+			}
+		}
+		f.pat.WriteRune(r)
+	}
+}
+
+// addExpansion appends the result of an expansion. Quoted results are added
+// as is; unquoted ones are split into fields on the characters of IFS.
+func (e *expander) addExpansion(text string, quoted bool) {
+	if quoted || !e.split {
+		e.add(text, quoted)
+		return
+	}
+
+	ifs, ok := e.s.LookupVar("IFS")
+	if !ok {
+		ifs = " \t\n"
+	}
+
+	for _, r := range text {
+		if !strings.ContainsRune(ifs, r) {
+			e.add(string(r), false)
+			continue
+		}
+
+		if strings.ContainsRune(" \t\n", r) {
+			// IFS whitespace ends the current field, if there is one.
+			if len(e.fields) > 0 && !e.brk {
+				if f := e.fields[len(e.fields)-1]; f.value.Len() > 0 || f.quoted {
+					e.brk = true
+				}
+			}
+			continue
+		}
+
+		// Other IFS characters always delimit a field, even an empty one.
+		f := e.cur()
+		f.quoted = true
+		e.brk = true
+	}
+}
+
+// expand processes the raw word, handling quotes, backslashes, tilde and
+// parameter expansion.
+func (e *expander) expand(word string) error {
+	rs := []rune(word)
+	i := 0
+
+	// A leading tilde expands to a home directory.
+	if len(rs) > 0 && rs[0] == '~' {
+		end := 1
+		for end < len(rs) && rs[end] != '/' {
+			end++
+		}
+		if dir, ok := e.s.expandTilde(string(rs[1:end])); ok {
+			e.add(dir, true)
+			i = end
+		}
+	}
+
+	// Make sure a word always produces at least the current field.
+	e.cur()
+
+	for i < len(rs) {
+		r := rs[i]
+
+		switch r {
+		case '\\':
+			if i+1 < len(rs) {
+				e.add(string(rs[i+1]), true)
+				i += 2
+				continue
+			}
+			e.add(`\`, true)
+			i++
+		case '\'':
+			end := i + 1
+			for end < len(rs) && rs[end] != '\'' {
+				end++
+			}
+			e.add(string(rs[i+1:min(end, len(rs))]), true)
+			i = end + 1
+		case '"':
+			n, err := e.expandDoubleQuoted(rs[i+1:])
+			if err != nil {
+				return err
+			}
+			i += n + 1
+		case '$':
+			n, err := e.expandParam(rs[i+1:], false)
+			if err != nil {
+				return err
+			}
+			i += n + 1
+		default:
+			e.add(string(r), false)
+			i++
+		}
+	}
+
+	return nil
+}
+
+// expandDoubleQuoted expands the contents of a double-quoted string starting
+// right after the opening quote. It returns the number of runes consumed,
+// including the closing quote.
+func (e *expander) expandDoubleQuoted(rs []rune) (int, error) {
+	// An empty pair of quotes still produces an (empty) argument.
+	e.cur().quoted = true
+
+	i := 0
+	for i < len(rs) {
+		r := rs[i]
+
+		switch r {
+		case '"':
+			return i + 1, nil
+		case '\\':
+			// Inside double quotes a backslash only escapes $, `, " , \ and newline.
+			if i+1 < len(rs) && strings.ContainsRune("$`\"\\\n", rs[i+1]) {
+				if rs[i+1] != '\n' {
+					e.add(string(rs[i+1]), true)
+				}
+				i += 2
+				continue
+			}
+			e.add(`\`, true)
+			i++
+		case '$':
+			n, err := e.expandParam(rs[i+1:], true)
+			if err != nil {
+				return 0, err
+			}
+			i += n + 1
+		default:
+			e.add(string(r), true)
+			i++
+		}
+	}
+
+	return i, nil
+}
+
+// expandParam expands a parameter reference starting right after the '$'.
+// It returns the number of runes consumed. A '$' that does not start a
+// parameter reference is kept literally.
+func (e *expander) expandParam(rs []rune, quoted bool) (int, error) {
+	if len(rs) == 0 {
+		e.add("$", quoted)
+		return 0, nil
+	}
+
+	// ${...}
+	if rs[0] == '{' {
+		end := 1
+		for end < len(rs) && rs[end] != '}' {
+			end++
+		}
+		if end == len(rs) {
+			return 0, fmt.Errorf("${%s: bad substitution", string(rs[1:]))
+		}
+		if err := e.expandBraced(string(rs[1:end]), quoted); err != nil {
+			return 0, err
+		}
+		return end + 1, nil
+	}
+
+	// "$@" expands to one field per positional parameter.
+	if rs[0] == '@' && quoted && e.split {
+		e.addParams()
+		return 1, nil
+	}
+
+	// Special parameters are a single character: $?, $#, $$, $0, $1...
+	if strings.ContainsRune("?#$@*!-", rs[0]) || (rs[0] >= '0' && rs[0] <= '9') {
+		if v, ok := e.s.specialParam(string(rs[0])); ok {
+			e.addExpansion(v, quoted)
+		}
+		return 1, nil
+	}
+
+	// $NAME
+	n := 0
+	for n < len(rs) && (rs[n] == '_' || isAlnum(rs[n])) {
+		n++
+	}
+	if n == 0 || (rs[0] >= '0' && rs[0] <= '9') {
+		e.add("$", quoted)
+		return 0, nil
+	}
+
+	v, _ := e.s.LookupVar(string(rs[:n]))
+	e.addExpansion(v, quoted)
+
+	return n, nil
+}
+
+// addParams adds each positional parameter as a separate quoted field, the
+// first one joined to the preceding text and the last one to the following text.
+func (e *expander) addParams() {
+	for i, p := range e.s.params {
+		if i > 0 {
+			e.brk = true
+		}
+		e.add(p, true)
+	}
+}
+
+// expandBraced handles ${name}, ${#name} and the ${name:-word} family of
+// operators, as well as prefix/suffix removal with #, ##, % and %%.
+func (e *expander) expandBraced(expr string, quoted bool) error {
+	if name, sub, ok := splitSubscript(expr); ok {
+		return e.expandElement(name, sub, quoted)
+	}
+
+	// ${#name} is the length of the value.
+	if len(expr) > 1 && expr[0] == '#' {
+		v, _ := e.s.param(expr[1:])
+		e.addExpansion(strconv.Itoa(len([]rune(v))), quoted)
+		return nil
+	}
+
+	name, op, word := splitParamExpr(expr)
+	if name == "" {
+		return fmt.Errorf("${%s}: bad substitution", expr)
+	}
+
+	if name == "@" && op == "" && quoted && e.split {
+		e.addParams()
+		return nil
+	}
+
+	v, set := e.s.param(name)
+	if op == "" {
+		e.addExpansion(v, quoted)
+		return nil
+	}
+
+	// With a colon, an empty value is treated like an unset one.
+	empty := !set
+	if strings.HasPrefix(op, ":") {
+		empty = v == ""
+		op = op[1:]
+	}
+
+	switch op {
+	case "-", "=", "?", "+":
+		arg, err := e.s.expandString(word)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case op == "-" && empty:
+			v = arg
+		case op == "=" && empty:
+			if !nameRe.MatchString(name) {
+				return fmt.Errorf("$%s: cannot assign in this way", name)
+			}
+			e.s.setVar(name, arg)
+			v = arg
+		case op == "?" && empty:
+			if arg == "" {
+				arg = "parameter null or not set"
+			}
+			return fmt.Errorf("%s: %s", name, arg)
+		case op == "+":
+			if empty {
+				v = ""
+			} else {
+				v = arg
+			}
+		}
+	case "#", "##", "%", "%%":
+		pat, err := e.s.expandPattern(word)
+		if err != nil {
+			return err
+		}
+		v = trimPattern(v, pat, op)
+	}
+
+	e.addExpansion(v, quoted)
+
+	return nil
+}
+
+// splitSubscript splits an array reference "name[sub]" or "#name[sub]" into
+// the name (with its leading '#') and the subscript.
+func splitSubscript(expr string) (name, sub string, ok bool) {
+	open := strings.IndexByte(expr, '[')
+	if open <= 0 || !strings.HasSuffix(expr, "]") {
+		return "", "", false
+	}
+	name = expr[:open]
+	if !nameRe.MatchString(strings.TrimPrefix(name, "#")) {
+		return "", "", false
+	}
+	return name, expr[open+1 : len(expr)-1], true
+}
+
+// expandElement expands ${name[i]}, ${name[@]}, ${name[*]} and their lengths
+// ${#name[@]} and ${#name[i]}. Like "$@", a quoted ${name[@]} produces one
+// field per element. A scalar variable behaves as an array of one element.
+func (e *expander) expandElement(name, sub string, quoted bool) error {
+	length := strings.HasPrefix(name, "#")
+	name = strings.TrimPrefix(name, "#")
+
+	elems, ok := e.s.arrays[name]
+	if !ok {
+		if v, set := e.s.LookupVar(name); set {
+			elems = []string{v}
+		}
+	}
+
+	if sub == "@" || sub == "*" {
+		switch {
+		case length:
+			e.addExpansion(strconv.Itoa(len(elems)), quoted)
+		case sub == "@" && quoted && e.split:
+			for i, v := range elems {
+				if i > 0 {
+					e.brk = true
+				}
+				e.add(v, true)
+			}
+		default:
+			e.addExpansion(strings.Join(elems, " "), quoted)
+		}
+		return nil
+	}
+
+	idx, err := e.s.expandString(sub)
+	if err != nil {
+		return err
+	}
+	i, err := strconv.Atoi(strings.TrimSpace(idx))
+	if err != nil {
+		return fmt.Errorf("%s: bad array subscript", sub)
+	}
+	if i < 0 {
+		i += len(elems)
+	}
+
+	var v string
+	if i >= 0 && i < len(elems) {
+		v = elems[i]
+	}
+	if length {
+		v = strconv.Itoa(len([]rune(v)))
+	}
+	e.addExpansion(v, quoted)
+
+	return nil
+}
+
+// splitParamExpr splits the contents of ${...} into the parameter name,
+// the operator and the operator's argument.
+func splitParamExpr(expr string) (name, op, word string) {
+	n := 0
+	switch {
+	case expr == "":
+		return "", "", ""
+	case strings.ContainsRune("?#$@*!-", rune(expr[0])):
+		n = 1
+	default:
+		for n < len(expr) && (expr[n] == '_' || isAlnum(rune(expr[n]))) {
+			n++
+		}
+	}
+	name, rest := expr[:n], expr[n:]
+
+	for _, o := range []string{":-", ":=", ":?", ":+", "##", "%%", "-", "=", "?", "+", "#", "%"} {
+		if strings.HasPrefix(rest, o) {
+			return name, o, rest[len(o):]
+		}
+	}
+	if rest != "" {
+		return "", "", ""
+	}
+
+	return name, "", ""
+}
+
+// param returns the value of a named or special parameter and whether it is set.
+func (s *Shell) param(name string) (string, bool) {
+	if v, ok := s.specialParam(name); ok {
+		if n, err := strconv.Atoi(name); err == nil && n > len(s.params) {
+			return "", false
+		}
+		return v, true
+	}
+	return s.LookupVar(name)
+}
+
+// expandPattern expands a word used as a pattern (e.g. in ${name#pattern}).
+// Quoted characters are escaped so that they only match themselves.
+func (s *Shell) expandPattern(word string) (string, error) {
+	e := &expander{s: s}
+	if err := e.expand(word); err != nil {
+		return "", err
+	}
+
+	var parts []string
+	for _, f := range e.fields {
+		parts = append(parts, f.pat.String())
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+// expandRegexp expands the right side of "[[ word =~ regexp ]]".
+// Quoted characters are escaped so that they only match themselves.
+func (s *Shell) expandRegexp(word string) (string, error) {
+	e := &expander{s: s}
+	if err := e.expand(word); err != nil {
+		return "", err
+	}
+
+	var parts []string
+	for _, f := range e.fields {
+		parts = append(parts, f.re.String())
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+// trimPattern removes the shortest (# and %) or longest (## and %%) prefix or
+// suffix of v matching the pattern.
+func trimPattern(v, pat, op string) string {
+	rs := []rune(v)
+
+	switch op {
+	case "#":
+		for i := 0; i <= len(rs); i++ {
+			if matchPattern(pat, string(rs[:i]), false) {
+				return string(rs[i:])
+			}
+		}
+	case "##":
+		for i := len(rs); i >= 0; i-- {
+			if matchPattern(pat, string(rs[:i]), false) {
+				return string(rs[i:])
+			}
+		}
+	case "%":
+		for i := len(rs); i >= 0; i-- {
+			if matchPattern(pat, string(rs[i:]), false) {
+				return string(rs[:i])
+			}
+		}
+	case "%%":
+		for i := 0; i <= len(rs); i++ {
+			if matchPattern(pat, string(rs[i:]), false) {
+				return string(rs[:i])
+			}
+		}
+	}
+
+	return v
+}
+
+// expandTilde returns the directory a tilde prefix refers to:
+// "" is the home directory, "+" is $PWD, "-" is $OLDPWD, "N", "+N" and "-N" are
+// entries of the directory stack (as listed by dirs) and anything else is a user name.
+func (s *Shell) expandTilde(prefix string) (string, bool) {
+	switch prefix {
+	case "":
+		if home, ok := s.LookupVar("HOME"); ok {
+			return home, true
+		}
+		home, err := os.UserHomeDir()
+		return home, err == nil
+	case "+":
+		return s.LookupVar("PWD")
+	case "-":
+		return s.LookupVar("OLDPWD")
+	}
+
+	if prefix[0] >= '0' && prefix[0] <= '9' {
+		prefix = "+" + prefix
+	}
+	stack := s.dirStack()
+	if i, ok, err := stackIndex(prefix, len(stack)); ok {
+		return stack[i], err == nil
+	}
+
+	u, err := user.Lookup(prefix)
+	if err != nil {
+		return "", false
+	}
+
+	return u.HomeDir, true
+}
+
+// isAlnum reports whether r is an ASCII letter or digit.
+func isAlnum(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
@@ -0,0 +1,109 @@
+package shell
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// builtinExport implements "export [name[=value]...]": move variables to the
+// environment, so that child processes see them. Without arguments (or with -p)
+// it lists the environment in a form that can be read back by the shell.
+// Startup files use it to set up the environment of the session.
+func (s *Shell) builtinExport(args []string, st *stdio) error {
+	if len(args) == 0 || (len(args) == 1 && args[0] == "-p") {
+		env := s.environ()
+		sort.Strings(env)
+		for _, kv := range env {
+			name, value, _ := strings.Cut(kv, "=")
+			_, _ = fmt.Fprintf(st.out, "export %s=%s\n", name, quote(value))
+		}
+		return nil
+	}
+
+	var failed bool
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !nameRe.MatchString(name) {
+			_, _ = fmt.Fprintf(st.err, "shell: export: `%s': not a valid identifier\n", arg)
+			failed = true
+			continue
+		}
+
+		// Without a value, export the current value of the shell variable, if any.
+		if !hasValue {
+			v, ok := s.vars[name]
+			if !ok {
+				continue
+			}
+			value = v
+		}
+
+		delete(s.vars, name)
+		s.setEnv(name, value)
+	}
+
+	if failed {
+		return &StatusError{Code: 1}
+	}
+	return nil
+}
+
+// builtinUnset implements "unset [-f|-v] name...": remove variables or,
+// with -f, functions. Without a flag, a variable is removed if one exists,
+// otherwise a function of that name.
+func (s *Shell) builtinUnset(args []string, _ *stdio) error {
+	mode := ""
+	if len(args) > 0 && (args[0] == "-f" || args[0] == "-v") {
+		mode, args = args[0], args[1:]
+	}
+
+	for _, name := range args {
+		if mode != "-f" && s.unsetVar(name) {
+			continue
+		}
+		if mode != "-v" {
+			delete(s.funcs, name)
+		}
+	}
+
+	return nil
+}
+
+// unsetVar removes a variable and reports whether it was set. A local
+// variable of a running function is only marked unset in its scope.
+func (s *Shell) unsetVar(name string) bool {
+	for i := len(s.scopes) - 1; i >= 0; i-- {
+		if v, ok := s.scopes[i][name]; ok {
+			s.scopes[i][name] = nil
+			return v != nil
+		}
+	}
+
+	if _, ok := s.vars[name]; ok {
+		delete(s.vars, name)
+		return true
+	}
+
+	if _, ok := s.arrays[name]; ok {
+		delete(s.arrays, name)
+		return true
+	}
+
+	if _, ok := s.lookupEnv(name); ok {
+		s.unsetEnv(name)
+		return true
+	}
+
+	return false
+}
+
+// quote returns s quoted for the shell, so that it reads back as a single word.
+func quote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(isAlnum(r) || strings.ContainsRune("_-./:=@%+,", r))
+	}) == -1 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
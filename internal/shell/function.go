@@ -0,0 +1,126 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultFuncNest is the maximum depth of nested function calls, unless
+// FUNCNEST says otherwise. It keeps runaway recursion from exhausting the Go stack.
+const defaultFuncNest = 1000
+
+// funcReturn is returned by the return builtin and unwinds the running
+// function up to callFunction, or the sourced file up to runScript.
+type funcReturn struct {
+	code int
+}
+
+func (e *funcReturn) Error() string {
+	return "return " + strconv.Itoa(e.code)
+}
+
+// callFunction runs a shell function with the given arguments as positional
+// parameters and a fresh scope for its local variables.
+func (s *Shell) callFunction(name string, body *Command, args []string, st *stdio) error {
+	limit := defaultFuncNest
+	if v, err := strconv.Atoi(s.Var("FUNCNEST")); err == nil && v > 0 {
+		limit = v
+	}
+	if len(s.scopes) >= limit {
+		return fmt.Errorf("%s: maximum function nesting level exceeded (%d)", name, limit)
+	}
+
+	// Save the caller's state: positional parameters and loop depth
+	// (break and continue do not cross function boundaries).
+	params, loops := s.params, s.loops
+	s.params, s.loops = args, 0
+	s.scopes = append(s.scopes, scope{})
+	defer func() {
+		s.params, s.loops = params, loops
+		s.scopes = s.scopes[:len(s.scopes)-1]
+	}()
+
+	err := s.runCommand(body, st)
+
+	// "return n" ends the function with status n.
+	var ret *funcReturn
+	if errors.As(err, &ret) {
+		if ret.code == 0 {
+			return nil
+		}
+		return &StatusError{Code: ret.code}
+	}
+
+	return err
+}
+
+// builtinLocal implements "local name[=value]...", declaring variables local
+// to the running function. Functions it calls see them too (dynamic scoping).
+func (s *Shell) builtinLocal(args []string, st *stdio) error {
+	if len(s.scopes) == 0 {
+		return fmt.Errorf("local: can only be used in a function")
+	}
+	cur := s.scopes[len(s.scopes)-1]
+
+	// Without arguments, list the local variables.
+	if len(args) == 0 {
+		names := make([]string, 0, len(cur))
+		for name := range cur {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if v := cur[name]; v != nil {
+				_, _ = fmt.Fprintf(st.out, "%s=%s\n", name, *v)
+			}
+		}
+		return nil
+	}
+
+	var failed bool
+	for _, arg := range args {
+		name, value, hasValue := strings.Cut(arg, "=")
+		if !nameRe.MatchString(name) {
+			_, _ = fmt.Fprintf(st.err, "shell: local: `%s': not a valid identifier\n", arg)
+			failed = true
+			continue
+		}
+
+		if hasValue {
+			cur[name] = &value
+		} else if _, ok := cur[name]; !ok {
+			cur[name] = nil
+		}
+	}
+
+	if failed {
+		return &StatusError{Code: 1}
+	}
+	return nil
+}
+
+// builtinReturn implements "return [n]": leave the running function or
+// sourced file with status n, or with the status of the last command.
+func (s *Shell) builtinReturn(args []string, _ *stdio) error {
+	if len(s.scopes) == 0 && s.sourcing == 0 {
+		return fmt.Errorf("return: can only `return' from a function or sourced script")
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("return: %w", ErrTooManyArguments)
+	}
+
+	code := s.status
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("return: %s: numeric argument required", args[0])
+		}
+		code = n & 0xff
+	}
+
+	return &funcReturn{code: code}
+}
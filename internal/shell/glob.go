@@ -0,0 +1,286 @@
+package shell
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// hasMeta reports whether the pattern contains unescaped pattern characters (*, ? or [).
+func hasMeta(pattern string) bool {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '*', '?', '[':
+			return true
+		}
+	}
+	return false
+}
+
+// unescapePattern removes the backslashes that quote characters in a pattern.
+func unescapePattern(pattern string) string {
+	if !strings.Contains(pattern, `\`) {
+		return pattern
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		b.WriteByte(pattern[i])
+	}
+	return b.String()
+}
+
+// matchPattern reports whether name matches the shell pattern.
+// Supported syntax: '*' (any string), '?' (any character), bracket expressions
+// such as [abc], [a-z], [!0-9] and [[:alpha:]], and '\' to quote a character.
+// When pathname is true, wildcards never match '/', as in pathname expansion.
+func matchPattern(pattern, name string, pathname bool) bool {
+	return match([]rune(pattern), []rune(name), pathname)
+}
+
+// match is the recursive worker behind matchPattern.
+func match(pat, name []rune, pathname bool) bool {
+	for len(pat) > 0 {
+		switch pat[0] {
+		case '*':
+			// Collapse consecutive stars, then try every possible split.
+			for len(pat) > 0 && pat[0] == '*' {
+				pat = pat[1:]
+			}
+			if len(pat) == 0 {
+				return !pathname || !containsRune(name, '/')
+			}
+			for i := 0; i <= len(name); i++ {
+				if match(pat, name[i:], pathname) {
+					return true
+				}
+				if i < len(name) && pathname && name[i] == '/' {
+					return false
+				}
+			}
+			return false
+		case '?':
+			if len(name) == 0 || (pathname && name[0] == '/') {
+				return false
+			}
+			pat, name = pat[1:], name[1:]
+		case '[':
+			if len(name) == 0 || (pathname && name[0] == '/') {
+				return false
+			}
+			ok, n := matchBracket(pat, name[0])
+			if n == 0 {
+				// Unterminated bracket: treat '[' literally.
+				if name[0] != '[' {
+					return false
+				}
+				pat, name = pat[1:], name[1:]
+				continue
+			}
+			if !ok {
+				return false
+			}
+			pat, name = pat[n:], name[1:]
+		case '\\':
+			if len(pat) > 1 {
+				pat = pat[1:]
+			}
+			fallthrough
+		default:
+			if len(name) == 0 || pat[0] != name[0] {
+				return false
+			}
+			pat, name = pat[1:], name[1:]
+		}
+	}
+
+	return len(name) == 0
+}
+
+// charClasses maps the names usable in [[:name:]] to their predicates.
+var charClasses = map[string]func(rune) bool{
+	"alnum":  func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) },
+	"alpha":  unicode.IsLetter,
+	"blank":  func(r rune) bool { return r == ' ' || r == '\t' },
+	"cntrl":  unicode.IsControl,
+	"digit":  unicode.IsDigit,
+	"graph":  func(r rune) bool { return unicode.IsGraphic(r) && !unicode.IsSpace(r) },
+	"lower":  unicode.IsLower,
+	"print":  unicode.IsPrint,
+	"punct":  unicode.IsPunct,
+	"space":  unicode.IsSpace,
+	"upper":  unicode.IsUpper,
+	"xdigit": func(r rune) bool { return strings.ContainsRune("0123456789abcdefABCDEF", r) },
+}
+
+// matchBracket matches a single character against the bracket expression at the
+// start of pat. It returns whether the character matched and the length of the
+// expression, or 0 if the expression is not terminated.
+func matchBracket(pat []rune, c rune) (bool, int) {
+	i := 1
+	negate := false
+	if i < len(pat) && (pat[i] == '!' || pat[i] == '^') {
+		negate = true
+		i++
+	}
+
+	matched := false
+	first := true
+	for i < len(pat) {
+		r := pat[i]
+
+		// A ']' right after the opening bracket is a literal character.
+		if r == ']' && !first {
+			return matched != negate, i + 1
+		}
+		first = false
+
+		// Character class such as [:alpha:].
+		if r == '[' && i+1 < len(pat) && pat[i+1] == ':' {
+			end := indexRunes(pat[i+2:], ":]")
+			if end >= 0 {
+				if fn, ok := charClasses[string(pat[i+2:i+2+end])]; ok && fn(c) {
+					matched = true
+				}
+				i += end + 4
+				continue
+			}
+		}
+
+		if r == '\\' && i+1 < len(pat) {
+			i++
+			r = pat[i]
+		}
+
+		// Range such as a-z.
+		if i+2 < len(pat) && pat[i+1] == '-' && pat[i+2] != ']' {
+			hi := pat[i+2]
+			if hi == '\\' && i+3 < len(pat) {
+				hi = pat[i+3]
+				i++
+			}
+			if r <= c && c <= hi {
+				matched = true
+			}
+			i += 3
+			continue
+		}
+
+		if r == c {
+			matched = true
+		}
+		i++
+	}
+
+	return false, 0
+}
+
+// containsRune reports whether r is in rs.
+func containsRune(rs []rune, r rune) bool {
+	for _, x := range rs {
+		if x == r {
+			return true
+		}
+	}
+	return false
+}
+
+// indexRunes returns the index of the first occurrence of sub in rs, or -1.
+func indexRunes(rs []rune, sub string) int {
+	s := []rune(sub)
+	for i := 0; i+len(s) <= len(rs); i++ {
+		if string(rs[i:i+len(s)]) == sub {
+			return i
+		}
+	}
+	return -1
+}
+
+// globPaths performs pathname expansion and returns the sorted list of
+// matching paths, or nil if nothing matches. Files starting with '.' are only
+// matched by patterns whose component starts with a literal '.'. Relative
+// patterns are matched in the working directory of the shell.
+func (s *Shell) globPaths(pattern string) []string {
+	parts := strings.Split(pattern, "/")
+	bases := []string{""}
+	if strings.HasPrefix(pattern, "/") {
+		bases = []string{"/"}
+		parts = parts[1:]
+	}
+
+	for i, part := range parts {
+		last := i == len(parts)-1
+		var next []string
+
+		for _, base := range bases {
+			if !hasMeta(part) {
+				// Literal component: only the final path needs to exist.
+				p := joinPath(base, unescapePattern(part))
+				if last {
+					if _, err := os.Lstat(s.path(p)); err != nil {
+						continue
+					}
+				}
+				next = append(next, p)
+				continue
+			}
+
+			dir := base
+			if dir == "" {
+				dir = "."
+			}
+			entries, err := os.ReadDir(s.path(dir))
+			if err != nil {
+				continue
+			}
+
+			for _, e := range entries {
+				name := e.Name()
+				if strings.HasPrefix(name, ".") && !strings.HasPrefix(part, ".") {
+					continue
+				}
+				if !matchPattern(part, name, true) {
+					continue
+				}
+				if !last && !isDir(s.path(joinPath(base, name))) {
+					continue
+				}
+				next = append(next, joinPath(base, name))
+			}
+		}
+
+		bases = next
+		if len(bases) == 0 {
+			return nil
+		}
+	}
+
+	sort.Strings(bases)
+
+	return bases
+}
+
+// joinPath appends name to a directory produced during pathname expansion.
+func joinPath(base, name string) string {
+	switch base {
+	case "":
+		return name
+	case "/":
+		return "/" + name
+	default:
+		return base + "/" + name
+	}
+}
+
+// isDir reports whether path is a directory, following symlinks.
+func isDir(path string) bool {
+	info, err := os.Stat(filepath.Clean(path))
+	return err == nil && info.IsDir()
+}
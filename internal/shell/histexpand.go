@@ -0,0 +1,343 @@
+package shell
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// histSubst is the substitution of the last ":s" modifier, repeated by ":&".
+type histSubst struct {
+	old, new string
+}
+
+// ExpandHistory performs csh-style history expansion on a line read in an
+// interactive session, before it is parsed:
+//
+//	!!        the previous command        !n, !-n   command n, or n commands back
+//	!prefix   the last command starting with prefix
+//	!?text?   the last command containing text
+//	^old^new  the previous command with old replaced by new
+//
+// An event can be followed by a word designator, as in !:2, !$, !^, !* or
+// !!:1-3, and by modifiers: :h (directory), :t (file name), :r (without the
+// extension), :e (the extension), :s/old/new/ (substitution, :gs for all
+// occurrences), and :& (the last substitution again).
+//
+// A "!" followed by a blank, "=", "(" or the end of the line is kept, as is
+// one inside single quotes or escaped with a backslash. Nothing is expanded
+// if the histexpand option is off.
+func (s *Shell) ExpandHistory(line string) (string, error) {
+	if !s.options["histexpand"] {
+		return line, nil
+	}
+
+	if strings.HasPrefix(line, "^") {
+		return s.quickSubst(line)
+	}
+
+	var b strings.Builder
+	single, double := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && !single && i+1 < len(line):
+			b.WriteString(line[i : i+2])
+			i++
+			continue
+		case c == '\'' && !double:
+			single = !single
+		case c == '"' && !single:
+			double = !double
+		case c == '!' && !single:
+			text, end, err := s.histReference(line, i, double)
+			if err != nil {
+				return "", err
+			}
+			if end > i {
+				b.WriteString(text)
+				i = end - 1
+				continue
+			}
+		}
+		b.WriteByte(c)
+	}
+
+	return b.String(), nil
+}
+
+// quickSubst expands "^old^new^": the previous command with the first
+// occurrence of old replaced by new. Text after the final "^" is appended.
+func (s *Shell) quickSubst(line string) (string, error) {
+	old, rest, _ := strings.Cut(line[1:], "^")
+	repl, tail, _ := strings.Cut(rest, "^")
+
+	event, err := s.histEvent(len(s.history) - 1)
+	if err != nil {
+		return "", err
+	}
+	if old == "" || !strings.Contains(event, old) {
+		return "", fmt.Errorf("^%s^%s: substitution failed", old, repl)
+	}
+	s.lastSubst = histSubst{old: old, new: repl}
+	return strings.Replace(event, old, repl, 1) + tail, nil
+}
+
+// histReference expands the history reference starting with the "!" at
+// line[start]. It returns the expansion and the index following the
+// reference, which is start if the "!" does not start a reference.
+func (s *Shell) histReference(line string, start int, quoted bool) (string, int, error) {
+	i := start + 1
+	if i >= len(line) || strings.IndexByte(" \t\n=(", line[i]) >= 0 || quoted && line[i] == '"' {
+		return "", start, nil
+	}
+	// $! and ${!name} are parameter expansions.
+	if start > 0 && (line[start-1] == '$' || line[start-1] == '{' && start > 1 && line[start-2] == '$') {
+		return "", start, nil
+	}
+
+	// The event designator.
+	index := -1
+	last := len(s.history) - 1
+	switch c := line[i]; {
+	case c == '!':
+		index = last
+		i++
+	case c == '$' || c == '^' || c == '*' || c == ':':
+		// A word designator alone refers to the previous command.
+		index = last
+	case c >= '0' && c <= '9' || c == '-' && i+1 < len(line) && line[i+1] >= '0' && line[i+1] <= '9':
+		j := i + 1
+		for j < len(line) && line[j] >= '0' && line[j] <= '9' {
+			j++
+		}
+		n, _ := strconv.Atoi(line[i:j])
+		if n < 0 {
+			index = len(s.history) + n
+		} else {
+			index = n - s.histBase - 1
+		}
+		if index < 0 || index > last {
+			return "", 0, fmt.Errorf("%s: event not found", line[start:j])
+		}
+		i = j
+	case c == '?':
+		j := strings.IndexAny(line[i+1:], "?\n")
+		text, end := line[i+1:], len(line)
+		if j >= 0 {
+			text, end = line[i+1:i+1+j], i+2+j
+		}
+		index = s.searchHistory(func(cmd string) bool { return strings.Contains(cmd, text) })
+		if text == "" || index < 0 {
+			return "", 0, fmt.Errorf("%s: event not found", line[start:end])
+		}
+		i = end
+	default:
+		j := i
+		for j < len(line) && strings.IndexByte(" \t\n:;&|()<>'\"", line[j]) < 0 {
+			j++
+		}
+		prefix := line[i:j]
+		if prefix == "" {
+			return "", start, nil
+		}
+		index = s.searchHistory(func(cmd string) bool { return strings.HasPrefix(cmd, prefix) })
+		if index < 0 {
+			return "", 0, fmt.Errorf("%s: event not found", line[start:j])
+		}
+		i = j
+	}
+
+	event, err := s.histEvent(index)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// The word designator, introduced by ":", or directly by ^ $ * or -.
+	text := event
+	if i < len(line) && (strings.IndexByte("^$*-", line[i]) >= 0 ||
+		line[i] == ':' && i+1 < len(line) && strings.IndexByte("0123456789^$*-", line[i+1]) >= 0) {
+		if line[i] == ':' {
+			i++
+		}
+		if text, i, err = histWords(event, line, i); err != nil {
+			return "", 0, err
+		}
+	}
+
+	// Modifiers.
+	for i+1 < len(line) && line[i] == ':' {
+		text, i, err = s.histModifier(text, line, i+1)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+
+	return text, i, nil
+}
+
+// searchHistory returns the index of the last command of the history for
+// which match is true, or -1 if there is none.
+func (s *Shell) searchHistory(match func(string) bool) int {
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if match(s.history[i].line) {
+			return i
+		}
+	}
+	return -1
+}
+
+// histEvent returns the command at index of the history.
+func (s *Shell) histEvent(index int) (string, error) {
+	if index < 0 || index >= len(s.history) {
+		return "", fmt.Errorf("!!: event not found")
+	}
+	return s.history[index].line, nil
+}
+
+// histWords selects words of the command event with the word designator
+// starting at line[i]: n, ^ (1), $ (the last word), x-y, x- (up to the
+// word before the last), -y (from 0), x* (from x to the last word) or * (all
+// arguments). Words are numbered from 0, the command name. It returns the
+// selected words separated by spaces and the index following the designator.
+func histWords(event, line string, i int) (string, int, error) {
+	words := splitHistWords(event)
+	start := i
+
+	number := func() (int, bool) {
+		switch {
+		case i < len(line) && line[i] == '^':
+			i++
+			return 1, true
+		case i < len(line) && line[i] == '$':
+			i++
+			return len(words) - 1, true
+		}
+		j := i
+		for j < len(line) && line[j] >= '0' && line[j] <= '9' {
+			j++
+		}
+		if j == i {
+			return 0, false
+		}
+		n, _ := strconv.Atoi(line[i:j])
+		i = j
+		return n, true
+	}
+
+	var first, last int
+	if i < len(line) && line[i] == '*' {
+		i++
+		if len(words) < 2 {
+			return "", i, nil
+		}
+		first, last = 1, len(words)-1
+	} else {
+		n, ok := number()
+		if !ok {
+			n = 0 // "-y" starts at the command name.
+		}
+		first, last = n, n
+		switch {
+		case i < len(line) && line[i] == '*':
+			i++
+			last = len(words) - 1
+		case i < len(line) && line[i] == '-':
+			i++
+			if last, ok = number(); !ok {
+				last = len(words) - 2
+			}
+		case !ok:
+			return "", 0, fmt.Errorf("%s: bad word specifier", line[start:])
+		}
+	}
+
+	if first < 0 || first >= len(words) || last >= len(words) || last < first-1 {
+		return "", 0, fmt.Errorf("%s: bad word specifier", line[start:i])
+	}
+	return strings.Join(words[first:last+1], " "), i, nil
+}
+
+// splitHistWords splits a command into words as the lexer does, keeping
+// quotes, with operators such as "|" or ";" as words of their own.
+func splitHistWords(cmd string) []string {
+	var words []string
+	l := newLexer(cmd)
+	for {
+		tok, err := l.next()
+		if err != nil {
+			// An unterminated quote: the rest of the line is the last word.
+			return append(words, strings.TrimSpace(string(l.src[l.pos:])))
+		}
+		switch tok.kind {
+		case tokEOF:
+			return words
+		case tokWord, tokOp:
+			words = append(words, tok.val)
+		}
+	}
+}
+
+// histModifier applies the modifier starting at line[i] to text and returns
+// the result with the index following the modifier.
+func (s *Shell) histModifier(text, line string, i int) (string, int, error) {
+	global := false
+	if line[i] == 'g' && i+1 < len(line) && (line[i+1] == 's' || line[i+1] == '&') {
+		global = true
+		i++
+	}
+
+	switch line[i] {
+	case 'h':
+		if j := strings.LastIndex(text, "/"); j > 0 {
+			text = text[:j]
+		} else if j == 0 {
+			text = "/"
+		}
+	case 't':
+		text = text[strings.LastIndex(text, "/")+1:]
+	case 'r':
+		if j := strings.LastIndex(text, "."); j > strings.LastIndex(text, "/") {
+			text = text[:j]
+		}
+	case 'e':
+		if j := strings.LastIndex(text, "."); j > strings.LastIndex(text, "/") {
+			text = text[j+1:]
+		} else {
+			text = ""
+		}
+	case 's':
+		if i+1 >= len(line) {
+			return "", 0, fmt.Errorf(":s: substitution failed")
+		}
+		sep := line[i+1]
+		old, rest, _ := strings.Cut(line[i+2:], string(sep))
+		repl, _, found := strings.Cut(rest, string(sep))
+		i += 2 + len(old) + 1 + len(repl)
+		if !found {
+			// The final delimiter may be left out at the end of the line.
+			i = len(line) - 1
+		}
+		if old == "" {
+			old = s.lastSubst.old
+		}
+		// "&" in the replacement stands for the replaced text.
+		repl = strings.ReplaceAll(repl, "&", old)
+		s.lastSubst = histSubst{old: old, new: repl}
+		fallthrough
+	case '&':
+		sub := s.lastSubst
+		if sub.old == "" || !strings.Contains(text, sub.old) {
+			return "", 0, fmt.Errorf(":s/%s/%s/: substitution failed", sub.old, sub.new)
+		}
+		n := 1
+		if global {
+			n = -1
+		}
+		text = strings.Replace(text, sub.old, sub.new, n)
+	default:
+		return "", 0, fmt.Errorf(":%c: unrecognized history modifier", line[i])
+	}
+
+	return text, i + 1, nil
+}
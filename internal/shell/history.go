@@ -0,0 +1,376 @@
+package shell
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultHistSize is the number of commands kept when HISTSIZE is not set.
+const defaultHistSize = 500
+
+// histTimestamp matches the lines of the history file that hold the time of
+// the command that follows, e.g. "#1700000000".
+var histTimestamp = regexp.MustCompile(`^#[0-9]+$`)
+
+// histEntry is a command of the history with the time it was entered.
+type histEntry struct {
+	line string
+	time time.Time
+}
+
+// History returns the commands of the history, oldest first.
+func (s *Shell) History() []string {
+	lines := make([]string, len(s.history))
+	for i, e := range s.history {
+		lines[i] = e.line
+	}
+	return lines
+}
+
+// LoadHistory enables the command history, as done for interactive sessions:
+// it reads the history file, trimming it to HISTFILESIZE commands, and from
+// then on every command executed with ExecuteLine is added to the history and
+// appended to the file. The file is $HISTFILE, or ~/.minishell_history; an
+// empty HISTFILE keeps the history in memory only.
+func (s *Shell) LoadHistory() error {
+	s.histOn = true
+
+	path, err := s.historyFile()
+	if path == "" || err != nil {
+		return err
+	}
+
+	var entries []histEntry
+	err = updateHistoryFile(path, func(all []histEntry) []histEntry {
+		all = trimHistory(all, s.histLimit("HISTFILESIZE", s.histLimit("HISTSIZE", defaultHistSize)))
+		entries = all
+		return all
+	})
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+
+	s.history = append(s.history, entries...)
+	s.trimHistory()
+	return nil
+}
+
+// historyFile returns the path of the history file, or "" if the history is
+// not saved.
+func (s *Shell) historyFile() (string, error) {
+	if path, ok := s.LookupVar("HISTFILE"); ok {
+		return path, nil
+	}
+
+	home, err := s.homeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".minishell_history"), nil
+}
+
+// histLimit returns the number of commands allowed by the variable name
+// (HISTSIZE or HISTFILESIZE), or def if it is not set to a number. Negative
+// values mean no limit and are returned as -1.
+func (s *Shell) histLimit(name string, def int) int {
+	n, err := strconv.Atoi(s.Var(name))
+	if err != nil {
+		return def
+	}
+	return max(n, -1)
+}
+
+// trimHistory drops the oldest commands beyond HISTSIZE. The numbers of the
+// remaining commands do not change.
+func (s *Shell) trimHistory() {
+	n := len(s.history)
+	s.history = trimHistory(s.history, s.histLimit("HISTSIZE", defaultHistSize))
+	s.histBase += n - len(s.history)
+}
+
+// trimHistory returns the last limit entries, or all of them if limit is -1.
+func trimHistory(entries []histEntry, limit int) []histEntry {
+	if limit < 0 || len(entries) <= limit {
+		return entries
+	}
+	return slices.Clone(entries[len(entries)-limit:])
+}
+
+// addHistory adds a command to the history and appends it to the history
+// file, unless HISTCONTROL says to ignore it: "ignorespace" skips commands
+// starting with a space, "ignoredups" repeats of the previous command, and
+// "ignoreboth" does both. Blank lines are never added. A failure to write the
+// file is reported, but does not keep the command from running.
+func (s *Shell) addHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+
+	for _, c := range strings.Split(s.Var("HISTCONTROL"), ":") {
+		ignoreSpace := c == "ignorespace" || c == "ignoreboth"
+		ignoreDups := c == "ignoredups" || c == "ignoreboth"
+		if ignoreSpace && (line[0] == ' ' || line[0] == '\t') {
+			return
+		}
+		if ignoreDups && len(s.history) > 0 && s.history[len(s.history)-1].line == line {
+			return
+		}
+	}
+
+	e := histEntry{line: line, time: time.Now()}
+	s.history = append(s.history, e)
+	s.trimHistory()
+
+	path, err := s.historyFile()
+	if err == nil && path != "" {
+		err = appendHistoryFile(path, e)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "shell: history: %v\n", err)
+	}
+}
+
+// builtinHistory implements "history [n]", "history -c", "history -d offset"
+// and "history -w". Without options it lists the commands with their numbers,
+// or the last n of them. -c clears the history and -d deletes the command at
+// offset, or the commands from start to end with "-d start-end"; a negative
+// offset counts from the end. Both also remove the commands from the history
+// file. -w replaces the history file with the commands of this shell.
+func (s *Shell) builtinHistory(args []string, st *stdio) error {
+	if len(args) == 0 {
+		return s.listHistory(st, len(s.history))
+	}
+
+	path, err := s.historyFile()
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+
+	switch args[0] {
+	case "-c":
+		if len(args) > 1 {
+			return fmt.Errorf("history: %w", ErrTooManyArguments)
+		}
+		s.histBase += len(s.history)
+		s.history = nil
+		if path != "" {
+			err = updateHistoryFile(path, func([]histEntry) []histEntry { return nil })
+		}
+	case "-d":
+		if len(args) != 2 {
+			return fmt.Errorf("history: usage: history -d offset | -d start-end")
+		}
+		var start, end int
+		if start, end, err = s.histRange(args[1]); err != nil {
+			return fmt.Errorf("history: %w", err)
+		}
+		deleted := slices.Clone(s.history[start : end+1])
+		s.history = slices.Delete(s.history, start, end+1)
+		if path != "" {
+			err = updateHistoryFile(path, func(all []histEntry) []histEntry {
+				// Remove one file entry per deleted command: the same command
+				// may have been run more than once within a second.
+				for _, d := range deleted {
+					i := slices.IndexFunc(all, func(e histEntry) bool {
+						return e.line == d.line && e.time.Unix() == d.time.Unix()
+					})
+					if i >= 0 {
+						all = slices.Delete(all, i, i+1)
+					}
+				}
+				return all
+			})
+		}
+	case "-w":
+		if len(args) > 1 {
+			return fmt.Errorf("history: %w", ErrTooManyArguments)
+		}
+		if path != "" {
+			err = updateHistoryFile(path, func([]histEntry) []histEntry { return s.history })
+		}
+	default:
+		n, convErr := strconv.Atoi(args[0])
+		if convErr != nil || n < 0 {
+			if strings.HasPrefix(args[0], "-") {
+				return fmt.Errorf("history: %s: invalid option", args[0])
+			}
+			return fmt.Errorf("history: %s: numeric argument required", args[0])
+		}
+		if len(args) > 1 {
+			return fmt.Errorf("history: %w", ErrTooManyArguments)
+		}
+		return s.listHistory(st, n)
+	}
+
+	if err != nil {
+		return fmt.Errorf("history: %w", err)
+	}
+	return nil
+}
+
+// listHistory prints the last n commands of the history with their numbers.
+func (s *Shell) listHistory(st *stdio, n int) error {
+	first := max(len(s.history)-n, 0)
+	for i, e := range s.history[first:] {
+		if st.broken() {
+			return errBrokenPipe
+		}
+		_, _ = fmt.Fprintf(st.out, "%5d  %s\n", s.histBase+1+first+i, e.line)
+	}
+	return nil
+}
+
+// histRange converts the offset of "history -d", a command number or
+// "start-end", into indexes of the history. Negative numbers count back from
+// the end, -1 being the last command.
+func (s *Shell) histRange(spec string) (start, end int, err error) {
+	index := func(num string) (int, error) {
+		n, err := strconv.Atoi(num)
+		i := n - s.histBase - 1
+		if n < 0 {
+			i = len(s.history) + n
+		}
+		if err != nil || n == 0 || i < 0 || i >= len(s.history) {
+			return 0, fmt.Errorf("%s: history position out of range", spec)
+		}
+		return i, nil
+	}
+
+	// The dash of a negative start is not the separator of the range.
+	if sep := strings.Index(spec[min(1, len(spec)):], "-"); sep >= 0 {
+		sep++
+		if start, err = index(spec[:sep]); err != nil {
+			return 0, 0, err
+		}
+		if end, err = index(spec[sep+1:]); err != nil {
+			return 0, 0, err
+		}
+		if end < start {
+			return 0, 0, fmt.Errorf("%s: history position out of range", spec)
+		}
+		return start, end, nil
+	}
+
+	start, err = index(spec)
+	return start, start, err
+}
+
+// parseHistory reads the entries of a history file. Each command is preceded
+// by a "#unix time" line; the lines of a multi-line command follow each other.
+// Files without timestamps, as written by other shells, have one command per
+// line. A command that looks like a timestamp, such as the comment "#123", is
+// told apart by its position: right after a timestamp, or on the last line.
+func parseHistory(f *os.File) ([]histEntry, error) {
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var entries []histEntry
+	var stamp *time.Time
+	timed := false
+
+	for i, line := range lines {
+		if stamp == nil && i+1 < len(lines) && histTimestamp.MatchString(line) {
+			sec, err := strconv.ParseInt(line[1:], 10, 64)
+			if err == nil {
+				t := time.Unix(sec, 0)
+				stamp, timed = &t, true
+				continue
+			}
+		}
+
+		switch {
+		case stamp != nil:
+			entries = append(entries, histEntry{line: line, time: *stamp})
+			stamp = nil
+		case timed && len(entries) > 0:
+			entries[len(entries)-1].line += "\n" + line
+		default:
+			entries = append(entries, histEntry{line: line, time: time.Unix(0, 0)})
+		}
+	}
+
+	return entries, nil
+}
+
+// formatHistory returns an entry as written to the history file.
+func formatHistory(e histEntry) string {
+	return fmt.Sprintf("#%d\n%s\n", e.time.Unix(), e.line)
+}
+
+// openLocked opens a file shared by several shells, such as the history file,
+// creating it if needed, and locks it so that they can use it at the same time.
+func openLocked(path string, flag int) (*os.File, error) {
+	f, err := os.OpenFile(path, flag|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// appendHistoryFile appends an entry to the history file. The entry is
+// written at once while holding the lock, so that the commands of shells
+// running at the same time do not mix.
+func appendHistoryFile(path string, e histEntry) error {
+	f, err := openLocked(path, os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(formatHistory(e)); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// updateHistoryFile replaces the entries of the history file by the result of
+// change, while holding the lock. The file is rewritten in place rather than
+// replaced, so that other shells keep appending to the same file.
+func updateHistoryFile(path string, change func([]histEntry) []histEntry) error {
+	f, err := openLocked(path, os.O_RDWR)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	entries, err := parseHistory(f)
+	if err != nil {
+		return err
+	}
+	n := len(entries)
+	updated := change(entries)
+	if len(updated) == n && n > 0 && &updated[0] == &entries[0] {
+		// Nothing changed: leave the file alone.
+		return nil
+	}
+
+	var b strings.Builder
+	for _, e := range updated {
+		b.WriteString(formatHistory(e))
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt([]byte(b.String()), 0); err != nil {
+		return err
+	}
+	return nil
+}
@@ -1,33 +1,164 @@
 package shell
 
 import (
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"syscall"
 )
 
-// builtinKill sends a SIGTERM signal to the process with the given PID.
-// It validates arguments, parses the PID, and delegates the actual signal
-// sending to syscallKill.
-func builtinKill(args []string) error {
-	if len(args) < 1 {
-		return fmt.Errorf("kill: missed PID")
+// signalNames lists the Linux signal names, without the "SIG" prefix,
+// indexed by signal number.
+var signalNames = []string{
+	1: "HUP", 2: "INT", 3: "QUIT", 4: "ILL", 5: "TRAP", 6: "ABRT", 7: "BUS", 8: "FPE",
+	9: "KILL", 10: "USR1", 11: "SEGV", 12: "USR2", 13: "PIPE", 14: "ALRM", 15: "TERM",
+	16: "STKFLT", 17: "CHLD", 18: "CONT", 19: "STOP", 20: "TSTP", 21: "TTIN", 22: "TTOU",
+	23: "URG", 24: "XCPU", 25: "XFSZ", 26: "VTALRM", 27: "PROF", 28: "WINCH", 29: "IO",
+	30: "PWR", 31: "SYS",
+}
+
+// signalAliases maps alternative signal names to their numbers.
+var signalAliases = map[string]syscall.Signal{"IOT": 6, "CLD": 17, "POLL": 29}
+
+// parseSignal converts a signal given by number ("9"), name ("KILL") or
+// name with prefix ("SIGKILL", case-insensitive) to a signal. Signal 0 only
+// checks that the target exists.
+func parseSignal(spec string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(spec); err == nil {
+		if n < 0 || n >= len(signalNames) {
+			return 0, fmt.Errorf("%s: invalid signal specification", spec)
+		}
+		return syscall.Signal(n), nil
+	}
+
+	name := strings.TrimPrefix(strings.ToUpper(spec), "SIG")
+	for n, s := range signalNames {
+		if s != "" && s == name {
+			return syscall.Signal(n), nil
+		}
+	}
+	if sig, ok := signalAliases[name]; ok {
+		return sig, nil
 	}
 
-	pid, err := strconv.Atoi(args[0])
+	return 0, fmt.Errorf("%s: invalid signal specification", spec)
+}
+
+// builtinKill implements "kill [-s sig | -n num | -sig] target..." and
+// "kill -l [sig...]". Targets are PIDs, negative numbers for process groups,
+// or job specifications (%n). The signal defaults to SIGTERM. Each target is
+// signaled independently; kill fails if any of them could not be signaled.
+func (s *Shell) builtinKill(args []string, st *stdio) error {
+	sig := syscall.SIGTERM
+
+	if len(args) > 0 {
+		switch arg := args[0]; {
+		case arg == "-l" || arg == "-L":
+			return listSignals(args[1:], st)
+		case arg == "-s" || arg == "-n":
+			if len(args) < 2 {
+				return fmt.Errorf("kill: %s: option requires an argument", arg)
+			}
+			var err error
+			if sig, err = parseSignal(args[1]); err != nil {
+				return fmt.Errorf("kill: %w", err)
+			}
+			args = args[2:]
+		case arg == "--":
+			args = args[1:]
+		case len(arg) > 1 && arg[0] == '-':
+			var err error
+			if sig, err = parseSignal(arg[1:]); err != nil {
+				return fmt.Errorf("kill: %w", err)
+			}
+			args = args[1:]
+		}
+	}
+
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("kill: usage: kill [-s sigspec | -n signum | -sigspec] pid | jobspec ... or kill -l [sigspec]")
+	}
+
+	var failed bool
+	for _, target := range args {
+		if err := killTarget(target, sig); err != nil {
+			_, _ = fmt.Fprintf(st.err, "shell: kill: %v\n", err)
+			failed = true
+		}
+	}
+
+	if failed {
+		return &StatusError{Code: 1}
+	}
+	return nil
+}
+
+// killTarget sends sig to a single target: a PID, a process group given as
+// a negative PID, or a job specification.
+func killTarget(target string, sig syscall.Signal) error {
+	if strings.HasPrefix(target, "%") {
+		// Commands always run in the foreground, so there are no jobs to signal.
+		return fmt.Errorf("%s: no such job", target)
+	}
+
+	pid, err := strconv.Atoi(target)
 	if err != nil {
-		return fmt.Errorf("kill: invalid PID: %v", err)
+		return fmt.Errorf("%s: arguments must be process or job IDs", target)
 	}
 
-	if err = syscallKill(pid); err != nil {
-		return err
+	if err := syscall.Kill(pid, sig); err != nil {
+		var errno syscall.Errno
+		if errors.As(err, &errno) {
+			// Capitalize like the C library's messages: "No such process".
+			msg := errno.Error()
+			return fmt.Errorf("(%d) - %s", pid, strings.ToUpper(msg[:1])+msg[1:])
+		}
+		return fmt.Errorf("(%d) - %v", pid, err)
 	}
 
 	return nil
 }
 
-// syscallKill sends a SIGTERM signal to the process with the given PID.
-// It uses the low-level syscall.Kill function.
-func syscallKill(pid int) error {
-	return syscall.Kill(pid, syscall.SIGTERM)
+// listSignals implements "kill -l". Without arguments it prints a table of all
+// signals. Each argument is translated: a number to its signal name (an exit
+// status above 128 is taken as 128 plus a signal number) and a name to its number.
+func listSignals(args []string, st *stdio) error {
+	if len(args) == 0 {
+		for n := 1; n < len(signalNames); n++ {
+			sep := "\t"
+			if n%5 == 0 || n == len(signalNames)-1 {
+				sep = "\n"
+			}
+			_, _ = fmt.Fprintf(st.out, "%2d) SIG%-7s%s", n, signalNames[n], sep)
+		}
+		return nil
+	}
+
+	var failed bool
+	for _, arg := range args {
+		if n, err := strconv.Atoi(arg); err == nil {
+			if n > 128 {
+				n -= 128
+			}
+			if n > 0 && n < len(signalNames) {
+				_, _ = fmt.Fprintln(st.out, signalNames[n])
+				continue
+			}
+		} else if sig, err := parseSignal(arg); err == nil {
+			_, _ = fmt.Fprintln(st.out, int(sig))
+			continue
+		}
+
+		_, _ = fmt.Fprintf(st.err, "shell: kill: %s: invalid signal specification\n", arg)
+		failed = true
+	}
+
+	if failed {
+		return &StatusError{Code: 1}
+	}
+	return nil
 }
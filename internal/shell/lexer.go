@@ -0,0 +1,320 @@
+package shell
+
+import (
+	"strings"
+	"unicode"
+)
+
+// tokenKind identifies the kind of token produced by the lexer.
+type tokenKind int
+
+const (
+	tokEOF     tokenKind = iota // end of input
+	tokWord                     // a word, with its quotes still in place
+	tokOp                       // an operator such as |, &&, ;, < or >
+	tokNewline                  // an unquoted newline
+)
+
+// token is a single lexical unit of shell input.
+// Words keep their original quoting, so that reserved words can be told apart
+// from quoted ones ("if" is not a keyword) and expansion can apply quoting rules later.
+type token struct {
+	kind tokenKind
+	val  string
+	line int // line number of the token in the input, starting at 1
+}
+
+// operators lists the recognized operators, longest first,
+// so that "||" is matched before "|" and ";;&" before ";;" and ";".
+var operators = []string{";;&", "&&", "||", ";;", ";&", "|", "&", ";", "(", ")", "<", ">"}
+
+// lexer splits shell input into tokens. It is driven by the parser,
+// which pulls one token at a time.
+type lexer struct {
+	src   []rune
+	pos   int
+	start int // position of the last token returned
+
+	// Aliases being expanded, to prevent recursion, and the end of the last
+	// expanded alias whose value ends with a blank (-1 if none).
+	active    []activeAlias
+	blankTail int
+
+	// Position and line number of the last lineAt call,
+	// so that line numbers are computed incrementally.
+	linePos, line int
+}
+
+// newLexer returns a lexer reading from the given input.
+func newLexer(input string) *lexer {
+	return &lexer{src: []rune(input), line: 1, blankTail: -1}
+}
+
+// activeAlias records an alias whose value occupies src up to end.
+type activeAlias struct {
+	name string
+	end  int
+}
+
+// spliceAlias replaces the word just read, which names an alias, by the alias value.
+func (l *lexer) spliceAlias(name, value string) {
+	text := []rune(value)
+	delta := len(text) - (l.pos - l.start)
+
+	src := make([]rune, 0, len(l.src)+delta)
+	src = append(src, l.src[:l.start]...)
+	src = append(src, text...)
+	l.src = append(src, l.src[l.pos:]...)
+
+	// Shift the end of enclosing expansions that contain the word.
+	for i := range l.active {
+		if l.active[i].end >= l.pos {
+			l.active[i].end += delta
+		}
+	}
+
+	end := l.start + len(text)
+	l.active = append(l.active, activeAlias{name: name, end: end})
+	if strings.HasSuffix(value, " ") || strings.HasSuffix(value, "\t") {
+		l.blankTail = end
+	}
+	l.pos = l.start
+}
+
+// expanding reports whether the word just read comes from the value of the
+// alias name, in which case it must not be expanded again.
+func (l *lexer) expanding(name string) bool {
+	for _, a := range l.active {
+		if a.name == name && l.start < a.end {
+			return true
+		}
+	}
+	return false
+}
+
+// afterBlankAlias reports whether the word just read is the first one following
+// an alias value ending with a blank, which makes it subject to alias expansion.
+func (l *lexer) afterBlankAlias() bool {
+	if l.blankTail < 0 || l.start < l.blankTail {
+		return false
+	}
+	l.blankTail = -1
+	return true
+}
+
+// lineAt returns the line number of the given position.
+// Positions must be passed in increasing order.
+func (l *lexer) lineAt(pos int) int {
+	for ; l.linePos < pos && l.linePos < len(l.src); l.linePos++ {
+		if l.src[l.linePos] == '\n' {
+			l.line++
+		}
+	}
+	return l.line
+}
+
+// next returns the next token. It returns ErrIncomplete if the input ends
+// inside a quoted string or right after a line-continuation backslash.
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		r := l.src[l.pos]
+
+		switch {
+		case r == '\n':
+			l.start = l.pos
+			l.pos++
+			return token{kind: tokNewline, val: "\n", line: l.lineAt(l.pos - 1)}, nil
+		case r == '\\' && l.pos+1 < len(l.src) && l.src[l.pos+1] == '\n':
+			// Line continuation between words: drop both characters.
+			l.pos += 2
+		case unicode.IsSpace(r):
+			l.pos++
+		case r == '#':
+			// A comment runs until the end of the line.
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			if op := l.operator(); op != "" {
+				l.start = l.pos
+				line := l.lineAt(l.pos)
+				l.pos += len([]rune(op))
+				return token{kind: tokOp, val: op, line: line}, nil
+			}
+			return l.word()
+		}
+	}
+
+	l.start = l.pos
+	return token{kind: tokEOF, line: l.lineAt(l.pos)}, nil
+}
+
+// operator returns the operator starting at the current position, or "" if there is none.
+func (l *lexer) operator() string {
+	rest := string(l.src[l.pos:min(l.pos+3, len(l.src))])
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// word scans a single word. Quotes, backslash escapes and ${...} expansions
+// are kept verbatim in the result; only their extent is tracked here.
+func (l *lexer) word() (token, error) {
+	var b strings.Builder
+	l.start = l.pos
+	line := l.lineAt(l.pos)
+
+	for l.pos < len(l.src) {
+		r := l.src[l.pos]
+
+		if unicode.IsSpace(r) || l.operator() != "" {
+			break
+		}
+
+		switch r {
+		case '\\':
+			if l.pos+1 >= len(l.src) {
+				// Trailing backslash: the line continues on the next one.
+				return token{}, ErrIncomplete
+			}
+			if l.src[l.pos+1] == '\n' {
+				// Line continuation inside a word.
+				l.pos += 2
+				continue
+			}
+			b.WriteRune(r)
+			b.WriteRune(l.src[l.pos+1])
+			l.pos += 2
+		case '\'':
+			end := l.indexFrom(l.pos+1, '\'')
+			if end == -1 {
+				return token{}, ErrIncomplete
+			}
+			b.WriteString(string(l.src[l.pos : end+1]))
+			l.pos = end + 1
+		case '"':
+			end, err := l.doubleQuoteEnd(l.pos + 1)
+			if err != nil {
+				return token{}, err
+			}
+			b.WriteString(string(l.src[l.pos : end+1]))
+			l.pos = end + 1
+		case '$':
+			if l.pos+1 < len(l.src) && l.src[l.pos+1] == '{' {
+				end := l.indexFrom(l.pos+2, '}')
+				if end == -1 {
+					return token{}, ErrIncomplete
+				}
+				b.WriteString(string(l.src[l.pos : end+1]))
+				l.pos = end + 1
+				continue
+			}
+			b.WriteRune(r)
+			l.pos++
+		default:
+			b.WriteRune(r)
+			l.pos++
+		}
+	}
+
+	return token{kind: tokWord, val: b.String(), line: line}, nil
+}
+
+// regexWord scans the regular expression following "=~" inside [[ ]].
+// It ends at unquoted whitespace; parentheses, "|", "<" and ">" are part of
+// the word rather than operators. Quotes and escapes are kept, as in word.
+func (l *lexer) regexWord() (token, error) {
+	for l.pos < len(l.src) && (l.src[l.pos] == ' ' || l.src[l.pos] == '\t') {
+		l.pos++
+	}
+
+	var b strings.Builder
+	l.start = l.pos
+	line := l.lineAt(l.pos)
+
+	for l.pos < len(l.src) && !unicode.IsSpace(l.src[l.pos]) {
+		r := l.src[l.pos]
+
+		switch r {
+		case '\\':
+			if l.pos+1 >= len(l.src) {
+				return token{}, ErrIncomplete
+			}
+			b.WriteString(string(l.src[l.pos : l.pos+2]))
+			l.pos += 2
+		case '\'':
+			end := l.indexFrom(l.pos+1, '\'')
+			if end == -1 {
+				return token{}, ErrIncomplete
+			}
+			b.WriteString(string(l.src[l.pos : end+1]))
+			l.pos = end + 1
+		case '"':
+			end, err := l.doubleQuoteEnd(l.pos + 1)
+			if err != nil {
+				return token{}, err
+			}
+			b.WriteString(string(l.src[l.pos : end+1]))
+			l.pos = end + 1
+		default:
+			b.WriteRune(r)
+			l.pos++
+		}
+	}
+
+	if b.Len() == 0 {
+		return l.next()
+	}
+	return token{kind: tokWord, val: b.String(), line: line}, nil
+}
+
+// funcParens consumes a "()" following the word just read, as in a
+// function definition "name()". It reports whether the parentheses were found.
+func (l *lexer) funcParens() bool {
+	i := l.pos
+	skipBlanks := func() {
+		for i < len(l.src) && (l.src[i] == ' ' || l.src[i] == '\t') {
+			i++
+		}
+	}
+
+	skipBlanks()
+	if i >= len(l.src) || l.src[i] != '(' {
+		return false
+	}
+	i++
+	skipBlanks()
+	if i >= len(l.src) || l.src[i] != ')' {
+		return false
+	}
+
+	l.pos = i + 1
+	return true
+}
+
+// indexFrom returns the index of the first occurrence of r at or after start, or -1.
+func (l *lexer) indexFrom(start int, r rune) int {
+	for i := start; i < len(l.src); i++ {
+		if l.src[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// doubleQuoteEnd returns the index of the quote closing a double-quoted string
+// that starts at the given position, skipping backslash-escaped characters.
+func (l *lexer) doubleQuoteEnd(start int) (int, error) {
+	for i := start; i < len(l.src); i++ {
+		switch l.src[i] {
+		case '\\':
+			i++
+		case '"':
+			return i, nil
+		}
+	}
+	return -1, ErrIncomplete
+}
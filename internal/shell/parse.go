@@ -1,242 +1,857 @@
 package shell
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
 	"strings"
-	"unicode"
 )
 
+// ErrIncomplete is returned by Parse when the input ends in the middle of a command,
+// e.g. inside an unterminated quote, after a trailing pipe or inside an unfinished
+// if/while/for. Interactive callers should read another line and parse again.
+var ErrIncomplete = errors.New("unexpected end of input")
+
 // Command represents a single shell command (e.g., "ls", "echo"),
 // with its arguments and optional input/output redirection.
+// Words are stored as typed, with quotes; they are expanded right before execution.
 type Command struct {
-	Name   string   // Command name, e.g., "ls"
-	Args   []string // Command arguments
-	Input  string   // Input redirection ("<")
-	Output string   // Output redirection (">")
+	Name     string   // Command name, e.g., "ls"
+	Args     []string // Command arguments
+	Input    string   // Input redirection ("<")
+	Output   string   // Output redirection (">")
+	Assigns  []string // Variable assignments preceding the command ("NAME=value")
+	Arrays   []Array  // Array assignments ("NAME=(word...)")
+	Compound Compound // Compound command (if, while, for...); Name and Args are empty when set
+}
+
+// Array is an indexed array assignment "NAME=(word...)". The words are
+// expanded like the arguments of a command.
+type Array struct {
+	Name  string
+	Words []string
 }
 
 // Pipeline represents a sequence of commands connected via pipes (|),
 // and conditional execution with AND (&&) or OR (||) operators.
 type Pipeline struct {
 	Commands []*Command // Commands in the current pipeline
+	Negate   bool       // Pipeline is prefixed with "!"
+	Line     int        // Line number of the pipeline in the parsed input
 	AndNext  *Pipeline  // Next pipeline to execute on success (&&)
 	OrNext   *Pipeline  // Next pipeline to execute on failure (||)
 }
 
-// Parse takes a line of shell input and returns a Pipeline structure
-// representing commands, pipes, and conditional execution.
-func Parse(line string) (*Pipeline, error) {
-	tokens := tokenize(line)
+// List is a sequence of pipelines separated by ";" or newlines,
+// executed one after another.
+type List struct {
+	Items []*Pipeline
+}
+
+// Compound is implemented by the compound commands: if, while/until, for, case,
+// brace groups and function definitions.
+type Compound interface {
+	compound()
+}
 
-	return parseConditional(tokens)
+// IfClause represents "if cond; then body; [elif cond; then body;]... [else body;] fi".
+type IfClause struct {
+	Conds  []*List // Conditions of the if and of every elif
+	Bodies []*List // Bodies executed when the matching condition succeeds
+	Else   *List   // Else branch, nil if absent
 }
 
-// tokenize splits the input string into individual tokens,
-// including commands, arguments, operators (|, &&, ||), and redirection symbols (<, >).
-func tokenize(input string) []string {
-	var tokens []string
-	var b strings.Builder
+// LoopClause represents "while cond; do body; done" and "until cond; do body; done".
+type LoopClause struct {
+	Until bool  // Loop while the condition fails instead of while it succeeds
+	Cond  *List // Loop condition
+	Body  *List // Loop body
+}
 
-	flush := func() {
-		// Flush the current token buffer into the tokens slice.
-		if b.Len() > 0 {
-			tokens = append(tokens, b.String())
-			b.Reset()
-		}
+// ForClause represents "for name [in words]; do body; done".
+type ForClause struct {
+	Name   string   // Loop variable name
+	Words  []string // Words to iterate over, expanded before the loop starts
+	Params bool     // No "in" clause: iterate over the positional parameters
+	Body   *List    // Loop body
+}
+
+// CaseClause represents "case word in pattern|pattern) body ;; ... esac".
+type CaseClause struct {
+	Word  string      // Subject word, expanded without field splitting
+	Items []*CaseItem // Items in order of appearance
+}
+
+// CaseItem is a single "pattern|pattern) body ;;" item of a case command.
+type CaseItem struct {
+	Patterns []string // Glob patterns, any of which selects the item
+	Body     *List    // Commands to run, possibly empty
+	Term     string   // Terminator: ";;" (stop), ";&" (fall through) or ";;&" (keep testing)
+}
+
+// BraceGroup represents "{ list; }", a list run in the current shell.
+type BraceGroup struct {
+	Body *List
+}
+
+// FuncDef represents a function definition: "name() body" or "function name body".
+type FuncDef struct {
+	Name string   // Function name
+	Body *Command // Function body: a compound command with its redirections
+}
+
+// CondExpr represents "[[ expression ]]". Operands are expanded without
+// field splitting or pathname expansion.
+type CondExpr struct {
+	Expr condNode
+}
+
+func (*IfClause) compound()   {}
+func (*LoopClause) compound() {}
+func (*ForClause) compound()  {}
+func (*CaseClause) compound() {}
+func (*BraceGroup) compound() {}
+func (*FuncDef) compound()    {}
+func (*CondExpr) compound()   {}
+
+// nameRe matches a valid variable name.
+var nameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// funcNameRe matches a valid function name.
+var funcNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.:-]*$`)
+
+// assignRe matches a word of the form NAME=value.
+var assignRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// Parse takes shell input (possibly spanning several lines) and returns a List
+// of pipelines representing commands, pipes, conditional execution and
+// compound commands.
+func Parse(line string) (*List, error) {
+	return parse(line, 1, nil)
+}
+
+// parse is like Parse, with line numbers starting at firstLine
+// and the given aliases expanded.
+func parse(input string, firstLine int, aliases map[string]string) (*List, error) {
+	p := &parser{lex: newLexer(input), aliases: aliases}
+	p.lex.line = firstLine
+
+	list, err := p.parseList()
+	if err != nil {
+		return nil, err
 	}
 
-	runes := []rune(input)
-	for i := 0; i < len(runes); i++ {
-		r := runes[i]
+	// Anything left over is a stray terminator such as "fi" or "done".
+	tok, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if tok.kind != tokEOF {
+		return nil, syntaxError(tok)
+	}
+
+	return list, nil
+}
 
-		if unicode.IsSpace(r) {
-			// Space indicates token boundary.
-			flush()
-			continue
+// parser is a recursive descent parser over the tokens produced by the lexer.
+type parser struct {
+	lex     *lexer
+	tok     token             // lookahead token
+	has     bool              // tok holds a token that has not been consumed yet
+	aliases map[string]string // aliases to expand in command position
+}
+
+// expandAliases replaces the next word with the value of the alias it names,
+// repeatedly, as long as the alias is not already being expanded. The value is
+// fed back to the lexer, so aliases may contain operators and keywords.
+func (p *parser) expandAliases() error {
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if tok.kind != tokWord {
+			return nil
 		}
 
-		if r == '|' {
-			flush()
-			// Handle "||" as a single token
-			if i+1 < len(runes) && runes[i+1] == '|' {
-				tokens = append(tokens, "||")
-				i++
-			} else {
-				tokens = append(tokens, "|")
-			}
-			continue
+		value, ok := p.aliases[tok.val]
+		if !ok || p.lex.expanding(tok.val) {
+			return nil
 		}
 
-		if r == '&' {
-			flush()
-			// Handle "&&" as a single token
-			if i+1 < len(runes) && runes[i+1] == '&' {
-				tokens = append(tokens, "&&")
-				i++
-			} else {
-				tokens = append(tokens, "&") // standalone '&' not used in our shell
-			}
-			continue
+		p.lex.spliceAlias(tok.val, value)
+		p.has = false
+	}
+}
+
+// peek returns the next token without consuming it.
+func (p *parser) peek() (token, error) {
+	if !p.has {
+		tok, err := p.lex.next()
+		if err != nil {
+			return token{}, err
 		}
+		p.tok, p.has = tok, true
+	}
+	return p.tok, nil
+}
+
+// next consumes and returns the next token.
+func (p *parser) next() (token, error) {
+	tok, err := p.peek()
+	p.has = false
+	return tok, err
+}
 
-		if r == '>' || r == '<' {
-			flush()
-			tokens = append(tokens, string(r))
-			continue
+// skipNewlines consumes any newline tokens.
+func (p *parser) skipNewlines() error {
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if tok.kind != tokNewline {
+			return nil
 		}
+		p.has = false
+	}
+}
 
-		// Regular character: append to current token buffer.
-		b.WriteRune(r)
+// expect consumes the next token and checks that it is the given reserved word.
+func (p *parser) expect(word string) error {
+	tok, err := p.next()
+	if err != nil {
+		return err
 	}
+	if tok.kind == tokEOF {
+		return ErrIncomplete
+	}
+	if !isWord(tok, word) {
+		return syntaxError(tok)
+	}
+	return nil
+}
 
-	flush() // flush last token
+// isWord reports whether tok is the unquoted word w.
+func isWord(tok token, w string) bool {
+	return tok.kind == tokWord && tok.val == w
+}
 
-	return tokens
+// isOp reports whether tok is the operator op.
+func isOp(tok token, op string) bool {
+	return tok.kind == tokOp && tok.val == op
 }
 
-// indexOfCondOp returns the index of the first conditional operator (&& or ||)
-// in the token slice, or -1 if none are found.
-func indexOfCondOp(tokens []string) int {
-	for i, t := range tokens {
-		if t == "&&" || t == "||" {
-			return i
+// isTerminator reports whether tok ends a list: a reserved word closing a
+// compound command, or a closing operator.
+func isTerminator(tok token) bool {
+	switch tok.kind {
+	case tokWord:
+		switch tok.val {
+		case "then", "elif", "else", "fi", "do", "done", "esac", "}":
+			return true
+		}
+	case tokOp:
+		switch tok.val {
+		case ";;", ";&", ";;&":
+			return true
 		}
 	}
-	return -1
+	return false
 }
 
-// parseConditional parses tokens into a Pipeline, handling conditional operators (&& and ||).
-// It splits tokens at conditional operators and iteratively builds a linked chain of Pipelines.
-func parseConditional(tokens []string) (*Pipeline, error) {
-	if len(tokens) == 0 {
-		return nil, fmt.Errorf("empty command")
+// syntaxError reports an unexpected token.
+func syntaxError(tok token) error {
+	switch tok.kind {
+	case tokEOF:
+		return ErrIncomplete
+	case tokNewline:
+		return fmt.Errorf("syntax error near unexpected token `newline'")
+	default:
+		return fmt.Errorf("syntax error near unexpected token `%s'", tok.val)
 	}
+}
 
-	idx := indexOfCondOp(tokens)
-	if idx == -1 {
-		// No conditional operator: just a single pipeline.
-		return parsePipeline(tokens)
-	}
+// parseList parses pipelines separated by ";" or newlines,
+// stopping at the end of input or at a terminator such as "then" or "done".
+func (p *parser) parseList() (*List, error) {
+	list := &List{}
+
+	for {
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+
+		tok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if tok.kind == tokEOF || isTerminator(tok) {
+			return list, nil
+		}
 
-	// Parse the segment before the first conditional operator.
-	if idx == 0 {
-		return nil, fmt.Errorf("syntax error near %q", tokens[idx])
+		item, err := p.parseConditional()
+		if err != nil {
+			return nil, err
+		}
+		list.Items = append(list.Items, item)
+
+		// A pipeline is followed by a separator or by the end of the list.
+		tok, err = p.peek()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case isOp(tok, ";"), tok.kind == tokNewline:
+			p.has = false
+		case tok.kind == tokEOF, isTerminator(tok):
+			return list, nil
+		default:
+			return nil, syntaxError(tok)
+		}
 	}
-	left, err := parsePipeline(tokens[:idx])
+}
+
+// parseBody parses a non-empty list, as required for if conditions and loop bodies.
+func (p *parser) parseBody() (*List, error) {
+	list, err := p.parseList()
 	if err != nil {
 		return nil, err
 	}
 
-	cur := left
-	rest := tokens[idx:]
+	if len(list.Items) == 0 {
+		tok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		return nil, syntaxError(tok)
+	}
 
-	// Iteratively process remaining segments: operator + next segment.
-	for len(rest) > 0 {
-		op := rest[0] // "&&" or "||"
-		rest = rest[1:]
+	return list, nil
+}
 
-		if len(rest) == 0 {
-			return nil, fmt.Errorf("syntax error: expected command after %q", op)
-		}
+// parseConditional parses pipelines joined by conditional operators (&& and ||)
+// and builds a linked chain of Pipelines.
+func (p *parser) parseConditional() (*Pipeline, error) {
+	left, err := p.parsePipeline()
+	if err != nil {
+		return nil, err
+	}
 
-		nextIdx := indexOfCondOp(rest)
-		var seg []string
-		if nextIdx == -1 {
-			seg = rest
-			rest = nil
-		} else {
-			seg = rest[:nextIdx]
-			rest = rest[nextIdx:]
+	cur := left
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !isOp(tok, "&&") && !isOp(tok, "||") {
+			return left, nil
 		}
+		p.has = false
 
-		if len(seg) == 0 {
-			return nil, fmt.Errorf("syntax error: empty command after %q", op)
+		// The next pipeline may start on the following line.
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
 		}
 
-		right, err := parsePipeline(seg)
+		right, err := p.parsePipeline()
 		if err != nil {
 			return nil, err
 		}
 
 		// Link the current pipeline to the next based on the operator.
-		if op == "&&" {
+		if tok.val == "&&" {
 			cur.AndNext = right
 		} else {
 			cur.OrNext = right
 		}
 		cur = right
 	}
-
-	return left, nil
 }
 
-// parsePipeline converts tokens into a single Pipeline with multiple commands connected by pipes.
+// parsePipeline parses commands connected by pipes, optionally negated with "!".
 // For example, "echo hi | wc -w" will produce a Pipeline with two Commands.
-func parsePipeline(tokens []string) (*Pipeline, error) {
-	var cmds []*Command
-	var current []string
-
-	for _, token := range tokens {
-		if token == "|" {
-			// End of current command, create Command object.
-			cmd := parseCommand(current)
-			cmds = append(cmds, cmd)
-			current = nil
-		} else {
-			current = append(current, token)
+func (p *parser) parsePipeline() (*Pipeline, error) {
+	pl := &Pipeline{}
+
+	tok, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	pl.Line = tok.line
+	if isWord(tok, "!") {
+		p.has = false
+		pl.Negate = true
+	}
+
+	for {
+		cmd, err := p.parseCommand()
+		if err != nil {
+			return nil, err
+		}
+		pl.Commands = append(pl.Commands, cmd)
+
+		tok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !isOp(tok, "|") {
+			return pl, nil
+		}
+		p.has = false
+
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
 		}
 	}
+}
 
-	if len(current) > 0 {
-		// Last command in the pipeline.
-		cmd := parseCommand(current)
-		cmds = append(cmds, cmd)
+// parseCommand parses a simple command or a compound command
+// with its trailing redirections.
+func (p *parser) parseCommand() (*Command, error) {
+	// Aliases are expanded before reserved words are recognized.
+	if err := p.expandAliases(); err != nil {
+		return nil, err
 	}
 
-	return &Pipeline{Commands: cmds}, nil
+	tok, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+
+	var compound Compound
+	if tok.kind == tokWord {
+		switch tok.val {
+		case "if":
+			compound, err = p.parseIf()
+		case "while", "until":
+			compound, err = p.parseLoop()
+		case "for":
+			compound, err = p.parseFor()
+		case "case":
+			compound, err = p.parseCase()
+		case "{":
+			compound, err = p.parseBraceGroup()
+		case "[[":
+			compound, err = p.parseCond()
+		case "function":
+			compound, err = p.parseFunction()
+		default:
+			// "name()" starts a function definition.
+			if funcNameRe.MatchString(tok.val) && p.lex.funcParens() {
+				p.has = false
+				compound, err = p.parseFuncBody(tok.val)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if compound == nil {
+		return p.parseSimpleCommand()
+	}
+
+	cmd := &Command{Compound: compound}
+	if err := p.parseRedirects(cmd); err != nil {
+		return nil, err
+	}
+
+	return cmd, nil
 }
 
-// parseCommand converts a slice of tokens into a Command structure,
-// extracting the command name, arguments, and input/output redirection.
-func parseCommand(tokens []string) *Command {
+// parseSimpleCommand parses assignments, the command name, its arguments and
+// input/output redirection.
+func (p *parser) parseSimpleCommand() (*Command, error) {
 	cmd := &Command{}
-	i := 0
-
-	for i < len(tokens) {
-		token := tokens[i]
-
-		switch token {
-		case ">":
-			// Output redirection.
-			if i+1 < len(tokens) {
-				cmd.Output = tokens[i+1]
-				i += 2
-			} else {
-				i++
+
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+
+		// The command name may still be an alias after assignments, and an alias
+		// ending with a blank makes the following word subject to expansion too.
+		if tok.kind == tokWord && (cmd.Name == "" && len(cmd.Assigns) > 0 || p.lex.afterBlankAlias()) {
+			if err := p.expandAliases(); err != nil {
+				return nil, err
+			}
+			if tok, err = p.peek(); err != nil {
+				return nil, err
+			}
+		}
+
+		switch {
+		case tok.kind == tokWord:
+			p.has = false
+
+			switch {
+			case cmd.Name == "" && assignRe.MatchString(tok.val):
+				// NAME=value before the command name is an assignment,
+				// and NAME=(word...) an array assignment.
+				if name, ok := strings.CutSuffix(tok.val, "="); ok && nameRe.MatchString(name) {
+					next, err := p.peek()
+					if err != nil {
+						return nil, err
+					}
+					if isOp(next, "(") {
+						words, err := p.parseArrayWords()
+						if err != nil {
+							return nil, err
+						}
+						cmd.Arrays = append(cmd.Arrays, Array{Name: name, Words: words})
+						break
+					}
+				}
+				cmd.Assigns = append(cmd.Assigns, tok.val)
+			case cmd.Name == "":
+				// First word is the command name, subsequent words are arguments.
+				cmd.Name = tok.val
+			default:
+				cmd.Args = append(cmd.Args, tok.val)
 			}
-		case "<":
-			// Input redirection.
-			if i+1 < len(tokens) {
-				cmd.Input = tokens[i+1]
-				i += 2
-			} else {
-				i++
+		case isOp(tok, "<"), isOp(tok, ">"):
+			if err := p.parseRedirects(cmd); err != nil {
+				return nil, err
 			}
 		default:
-			//// Expand environment variables like $HOME.
-			//if strings.HasPrefix(token, "$") {
-			//	envName := token[1:]
-			//	token = os.Getenv(envName)
-			//}
-
-			// First token is the command name, subsequent tokens are arguments.
-			if cmd.Name == "" {
-				cmd.Name = token
-			} else {
-				cmd.Args = append(cmd.Args, token)
+			if cmd.Name == "" && len(cmd.Assigns) == 0 && len(cmd.Arrays) == 0 && cmd.Input == "" && cmd.Output == "" {
+				return nil, syntaxError(tok)
+			}
+			return cmd, nil
+		}
+	}
+}
+
+// parseArrayWords parses the words of an array assignment, from "(" to ")".
+// Newlines between the words are allowed.
+func (p *parser) parseArrayWords() ([]string, error) {
+	p.has = false
+
+	var words []string
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case tok.kind == tokWord:
+			words = append(words, tok.val)
+		case tok.kind == tokNewline:
+		case isOp(tok, ")"):
+			return words, nil
+		default:
+			return nil, syntaxError(tok)
+		}
+	}
+}
+
+// parseRedirects parses any "< file" and "> file" redirections that follow.
+func (p *parser) parseRedirects(cmd *Command) error {
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if !isOp(tok, "<") && !isOp(tok, ">") {
+			return nil
+		}
+		p.has = false
+
+		target, err := p.next()
+		if err != nil {
+			return err
+		}
+		if target.kind != tokWord {
+			if target.kind == tokEOF {
+				return syntaxError(token{kind: tokNewline})
+			}
+			return syntaxError(target)
+		}
+
+		if tok.val == "<" {
+			cmd.Input = target.val
+		} else {
+			cmd.Output = target.val
+		}
+	}
+}
+
+// parseIf parses "if list; then list; [elif list; then list;]... [else list;] fi".
+func (p *parser) parseIf() (*IfClause, error) {
+	clause := &IfClause{}
+	word := "if"
+
+	for word == "if" || word == "elif" {
+		p.has = false // consume "if" or "elif"
+
+		cond, err := p.parseBody()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		body, err := p.parseBody()
+		if err != nil {
+			return nil, err
+		}
+		clause.Conds = append(clause.Conds, cond)
+		clause.Bodies = append(clause.Bodies, body)
+
+		tok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		word = ""
+		if isWord(tok, "elif") {
+			word = "elif"
+		}
+	}
+
+	tok, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if isWord(tok, "else") {
+		p.has = false
+		if clause.Else, err = p.parseBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.expect("fi"); err != nil {
+		return nil, err
+	}
+
+	return clause, nil
+}
+
+// parseLoop parses "while list; do list; done" and "until list; do list; done".
+func (p *parser) parseLoop() (*LoopClause, error) {
+	tok, _ := p.next() // "while" or "until"
+	clause := &LoopClause{Until: tok.val == "until"}
+
+	var err error
+	if clause.Cond, err = p.parseBody(); err != nil {
+		return nil, err
+	}
+	if clause.Body, err = p.parseDoGroup(); err != nil {
+		return nil, err
+	}
+
+	return clause, nil
+}
+
+// parseFor parses "for name [in words]; do list; done".
+func (p *parser) parseFor() (*ForClause, error) {
+	p.has = false // consume "for"
+
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok.kind == tokEOF {
+		return nil, ErrIncomplete
+	}
+	if tok.kind != tokWord || !nameRe.MatchString(tok.val) {
+		return nil, syntaxError(tok)
+	}
+	clause := &ForClause{Name: tok.val, Params: true}
+
+	if err := p.skipNewlines(); err != nil {
+		return nil, err
+	}
+
+	tok, err = p.peek()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case isWord(tok, "in"):
+		p.has = false
+		clause.Params = false
+
+		// Collect words up to the ";" or newline that ends the word list.
+		for {
+			tok, err = p.next()
+			if err != nil {
+				return nil, err
+			}
+			if tok.kind != tokWord {
+				break
 			}
-			i++
+			clause.Words = append(clause.Words, tok.val)
+		}
+		if !isOp(tok, ";") && tok.kind != tokNewline {
+			return nil, syntaxError(tok)
 		}
+	case isOp(tok, ";"):
+		p.has = false
 	}
-	return cmd
+
+	if clause.Body, err = p.parseDoGroup(); err != nil {
+		return nil, err
+	}
+
+	return clause, nil
+}
+
+// parseDoGroup parses "do list; done".
+func (p *parser) parseDoGroup() (*List, error) {
+	if err := p.skipNewlines(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+
+	body, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect("done"); err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// parseCase parses "case word in [(]pattern[|pattern]...) list ;; ... esac".
+func (p *parser) parseCase() (*CaseClause, error) {
+	p.has = false // consume "case"
+
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok.kind != tokWord {
+		return nil, syntaxError(tok)
+	}
+	clause := &CaseClause{Word: tok.val}
+
+	if err := p.skipNewlines(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("in"); err != nil {
+		return nil, err
+	}
+
+	for {
+		if err := p.skipNewlines(); err != nil {
+			return nil, err
+		}
+
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if isWord(tok, "esac") {
+			return clause, nil
+		}
+
+		// The pattern list may be preceded by an optional "(".
+		if isOp(tok, "(") {
+			if tok, err = p.next(); err != nil {
+				return nil, err
+			}
+		}
+
+		item := &CaseItem{Term: ";;"}
+		for {
+			if tok.kind != tokWord {
+				return nil, syntaxError(tok)
+			}
+			item.Patterns = append(item.Patterns, tok.val)
+
+			// Patterns are separated by "|" and the list ends with ")".
+			if tok, err = p.next(); err != nil {
+				return nil, err
+			}
+			if isOp(tok, ")") {
+				break
+			}
+			if !isOp(tok, "|") {
+				return nil, syntaxError(tok)
+			}
+			if tok, err = p.next(); err != nil {
+				return nil, err
+			}
+		}
+
+		if item.Body, err = p.parseList(); err != nil {
+			return nil, err
+		}
+		clause.Items = append(clause.Items, item)
+
+		// The item ends with a terminator, or directly with "esac" for the last one.
+		tok, err = p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case isWord(tok, "esac"):
+			return clause, nil
+		case isOp(tok, ";;"), isOp(tok, ";&"), isOp(tok, ";;&"):
+			item.Term = tok.val
+		default:
+			return nil, syntaxError(tok)
+		}
+	}
+}
+
+// parseBraceGroup parses "{ list; }".
+func (p *parser) parseBraceGroup() (*BraceGroup, error) {
+	p.has = false // consume "{"
+
+	body, err := p.parseBody()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+
+	return &BraceGroup{Body: body}, nil
+}
+
+// parseFunction parses "function name [()] body".
+func (p *parser) parseFunction() (*FuncDef, error) {
+	p.has = false // consume "function"
+
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok.kind == tokEOF {
+		return nil, ErrIncomplete
+	}
+	if tok.kind != tokWord || !funcNameRe.MatchString(tok.val) {
+		return nil, syntaxError(tok)
+	}
+
+	// The parentheses are optional after the function keyword.
+	p.lex.funcParens()
+
+	return p.parseFuncBody(tok.val)
+}
+
+// parseFuncBody parses the body of a function definition, which must be a
+// compound command, usually a brace group.
+func (p *parser) parseFuncBody(name string) (*FuncDef, error) {
+	if err := p.skipNewlines(); err != nil {
+		return nil, err
+	}
+
+	body, err := p.parseCommand()
+	if err != nil {
+		return nil, err
+	}
+	if body.Compound == nil {
+		return nil, fmt.Errorf("syntax error: function body of %q must be a compound command", name)
+	}
+
+	return &FuncDef{Name: name, Body: body}, nil
 }
@@ -0,0 +1,253 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// pgrepOptions holds the parsed arguments of pgrep and pkill.
+type pgrepOptions struct {
+	pattern *regexp.Regexp
+	full    bool     // -f: match the full command line instead of the name
+	exact   bool     // -x: the pattern must match the whole name or command line
+	users   []string // -u: user names
+	ppids   []int32  // -P: parent process IDs
+	newest  bool     // -n: only the most recently started process
+	oldest  bool     // -o: only the least recently started process
+	list    bool     // -l: print the process name with the PID (pgrep)
+	sig     syscall.Signal
+}
+
+// builtinPgrep implements "pgrep [-flnox] [-u user] [-P ppid] [pattern]":
+// print the PIDs of the processes whose name (or command line, with -f)
+// matches the regular expression. The status is 1 if none matches.
+func (s *Shell) builtinPgrep(args []string, st *stdio) error {
+	opts, procs, err := pgrepMatch("pgrep", args, st)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range procs {
+		if opts.list {
+			_, _ = fmt.Fprintf(st.out, "%d %s\n", p.PID, p.Name)
+		} else {
+			_, _ = fmt.Fprintln(st.out, p.PID)
+		}
+	}
+
+	return nil
+}
+
+// builtinPkill implements "pkill [-signal] [-fnox] [-u user] [-P ppid] [pattern]":
+// send a signal (SIGTERM by default) to the processes pgrep would list, the
+// same way kill does. The status is 1 if no process could be signaled.
+func (s *Shell) builtinPkill(args []string, st *stdio) error {
+	opts, procs, err := pgrepMatch("pkill", args, st)
+	if err != nil {
+		return err
+	}
+
+	signaled := false
+	for _, p := range procs {
+		if err := killTarget(strconv.Itoa(int(p.PID)), opts.sig); err != nil {
+			_, _ = fmt.Fprintf(st.err, "shell: pkill: %v\n", err)
+			continue
+		}
+		signaled = true
+	}
+
+	if !signaled {
+		return &StatusError{Code: 1}
+	}
+	return nil
+}
+
+// pgrepMatch parses the arguments of pgrep or pkill and returns the matching
+// processes, ordered by PID. The shell itself is never matched. Invalid
+// arguments are reported with status 2, no match results in status 1.
+func pgrepMatch(name string, args []string, st *stdio) (*pgrepOptions, []*procInfo, error) {
+	opts, err := parsePgrepArgs(name, args)
+	if err != nil {
+		_, _ = fmt.Fprintf(st.err, "shell: %s: %v\n", name, err)
+		return nil, nil, &StatusError{Code: 2}
+	}
+
+	procs, err := listProcesses()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	procs = slices.DeleteFunc(procs, func(p *procInfo) bool { return !opts.match(p) })
+	slices.SortFunc(procs, func(a, b *procInfo) int { return int(a.PID - b.PID) })
+
+	if len(procs) > 0 && (opts.newest || opts.oldest) {
+		// Start times have a coarse resolution; on a tie the higher PID is
+		// taken as the newer process.
+		pick := procs[0]
+		for _, p := range procs[1:] {
+			if opts.newest && !p.Start.Before(pick.Start) || opts.oldest && p.Start.Before(pick.Start) {
+				pick = p
+			}
+		}
+		procs = []*procInfo{pick}
+	}
+
+	if len(procs) == 0 {
+		return nil, nil, &StatusError{Code: 1}
+	}
+	return opts, procs, nil
+}
+
+// parsePgrepArgs parses the options and pattern of pgrep or pkill. A signal
+// (-9, -KILL, --signal KILL) is only accepted by pkill.
+func parsePgrepArgs(name string, args []string) (*pgrepOptions, error) {
+	opts := &pgrepOptions{sig: syscall.SIGTERM}
+	var pattern []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			pattern = append(pattern, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			pattern = append(pattern, arg)
+			continue
+		}
+
+		// value returns the argument of an option, attached or following it.
+		value := func(opt string) (string, error) {
+			if rest := strings.TrimPrefix(arg, opt); rest != "" {
+				return strings.TrimPrefix(rest, "="), nil
+			}
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("%s: option requires an argument", opt)
+			}
+			i++
+			return args[i], nil
+		}
+
+		var err error
+		switch {
+		case strings.HasPrefix(arg, "--signal"):
+			if name != "pkill" {
+				return nil, fmt.Errorf("%s: invalid option", arg)
+			}
+			var v string
+			if v, err = value("--signal"); err == nil {
+				opts.sig, err = parseSignal(v)
+			}
+		case strings.HasPrefix(arg, "-u"):
+			var v string
+			if v, err = value("-u"); err == nil {
+				for _, u := range splitList(v) {
+					if u, err = lookupUser(u); err != nil {
+						break
+					}
+					opts.users = append(opts.users, u)
+				}
+			}
+		case strings.HasPrefix(arg, "-P"):
+			var v string
+			if v, err = value("-P"); err == nil {
+				opts.ppids, err = appendPids(opts.ppids, v)
+			}
+		case strings.Trim(arg[1:], "fxnol") == "":
+			// Flags, possibly combined: -f, -x, -n, -o and -l (pgrep only).
+			for _, r := range arg[1:] {
+				switch r {
+				case 'f':
+					opts.full = true
+				case 'x':
+					opts.exact = true
+				case 'n':
+					opts.newest = true
+				case 'o':
+					opts.oldest = true
+				case 'l':
+					if name != "pgrep" {
+						return nil, fmt.Errorf("%s: invalid option", arg)
+					}
+					opts.list = true
+				}
+			}
+		case name == "pkill":
+			// pkill -9, pkill -KILL, pkill -SIGKILL.
+			opts.sig, err = parseSignal(arg[1:])
+			if err != nil {
+				err = fmt.Errorf("%s: invalid option", arg)
+			}
+		default:
+			err = fmt.Errorf("%s: invalid option", arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.newest && opts.oldest {
+		return nil, fmt.Errorf("-n and -o cannot be used together")
+	}
+	if len(pattern) > 1 {
+		return nil, fmt.Errorf("only one pattern can be provided")
+	}
+	if len(pattern) == 0 && len(opts.users) == 0 && len(opts.ppids) == 0 {
+		return nil, fmt.Errorf("no matching criteria specified")
+	}
+
+	if len(pattern) == 1 {
+		expr := pattern[0]
+		if opts.exact {
+			expr = "^(?:" + expr + ")$"
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid regular expression", pattern[0])
+		}
+		opts.pattern = re
+	}
+
+	return opts, nil
+}
+
+// lookupUser returns the name of a user given by name or numeric ID, as the
+// process list shows it. An ID without a name stays numeric.
+func lookupUser(u string) (string, error) {
+	if _, err := user.Lookup(u); err == nil {
+		return u, nil
+	}
+	if _, err := strconv.Atoi(u); err == nil {
+		if usr, err := user.LookupId(u); err == nil {
+			return usr.Username, nil
+		}
+		return u, nil
+	}
+	return "", fmt.Errorf("invalid user name: %s", u)
+}
+
+// match reports whether a process matches all criteria.
+func (o *pgrepOptions) match(p *procInfo) bool {
+	if int(p.PID) == os.Getpid() {
+		return false
+	}
+	if len(o.users) > 0 && !slices.Contains(o.users, p.User) {
+		return false
+	}
+	if len(o.ppids) > 0 && !slices.Contains(o.ppids, p.PPID) {
+		return false
+	}
+	if o.pattern != nil {
+		subject := p.Name
+		if o.full {
+			subject = p.Command
+		}
+		return o.pattern.MatchString(subject)
+	}
+	return true
+}
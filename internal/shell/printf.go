@@ -0,0 +1,363 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// escapeMode selects which octal escapes are recognized by expandEscapes,
+// as the printf format string, printf's %b and echo -e differ.
+type escapeMode int
+
+const (
+	escFormat escapeMode = iota // \NNN, one to three octal digits
+	escB                        // \0NNN or \NNN
+	escEcho                     // \0NNN only
+)
+
+// expandEscapes interprets backslash escape sequences: \a \b \e \f \n \r \t \v
+// \\ \" \' octal escapes, \xHH, \uHHHH and \UHHHHHHHH. It reports whether a \c
+// was found, in which case the result ends there and no further output must
+// be produced. Unknown escapes are kept as is.
+func expandEscapes(s string, mode escapeMode) (string, bool) {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		c := s[i]
+		if r, ok := simpleEscapes[c]; ok {
+			b.WriteByte(r)
+			continue
+		}
+
+		switch {
+		case c == 'c':
+			return b.String(), true
+		case c >= '0' && c <= '7' && (c == '0' || mode != escEcho):
+			start, max := i, 3
+			if c == '0' && mode != escFormat {
+				// \0 is followed by up to three digits.
+				start, max = i+1, 3
+			}
+			n, width := parseDigits(s[start:], 8, max)
+			b.WriteByte(byte(n))
+			i = start + width - 1
+		case c == 'x' || c == 'u' || c == 'U':
+			max := map[byte]int{'x': 2, 'u': 4, 'U': 8}[c]
+			n, width := parseDigits(s[i+1:], 16, max)
+			if width == 0 {
+				b.WriteByte('\\')
+				b.WriteByte(c)
+				continue
+			}
+			if c == 'x' {
+				b.WriteByte(byte(n))
+			} else {
+				b.WriteRune(rune(n))
+			}
+			i += width
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String(), false
+}
+
+// simpleEscapes maps single-character escapes to the byte they stand for.
+var simpleEscapes = map[byte]byte{
+	'a': '\a', 'b': '\b', 'e': 0x1b, 'E': 0x1b, 'f': '\f', 'n': '\n',
+	'r': '\r', 't': '\t', 'v': '\v', '\\': '\\', '"': '"', '\'': '\'',
+}
+
+// parseDigits parses up to max leading digits of s in the given base and
+// returns the value and the number of digits used.
+func parseDigits(s string, base, max int) (int, int) {
+	n, i := 0, 0
+	for ; i < max && i < len(s); i++ {
+		d := strings.IndexByte("0123456789abcdef", s[i]|0x20)
+		if d < 0 || d >= base {
+			break
+		}
+		n = n*base + d
+	}
+	return n, i
+}
+
+// builtinPrintf implements "printf [-v var] format [arguments]". The format
+// is reused as long as arguments remain; missing arguments count as empty
+// strings or zero. With -v the output is assigned to var instead of printed.
+func (s *Shell) builtinPrintf(args []string, st *stdio) error {
+	var name string
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && args[0] != "-" {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		if args[0] != "-v" || len(args) < 2 {
+			return fmt.Errorf("printf: usage: printf [-v var] format [arguments]")
+		}
+		if !nameRe.MatchString(args[1]) {
+			return fmt.Errorf("printf: `%s': not a valid identifier", args[1])
+		}
+		name, args = args[1], args[2:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("printf: usage: printf [-v var] format [arguments]")
+	}
+
+	p := &printer{format: args[0], args: args[1:], st: st}
+	for {
+		used := p.pos
+		if done := p.run(); done || p.pos == len(p.args) || p.pos == used {
+			break
+		}
+	}
+
+	if name != "" {
+		s.setVar(name, p.out.String())
+	} else {
+		_, _ = fmt.Fprint(st.out, p.out.String())
+	}
+
+	if p.err != nil {
+		return p.err
+	}
+	if p.failed {
+		return &StatusError{Code: 1}
+	}
+	return nil
+}
+
+// printer formats the arguments of printf.
+type printer struct {
+	format string
+	args   []string
+	pos    int // next argument
+	st     *stdio
+	out    strings.Builder
+	failed bool  // an argument was not a valid number
+	err    error // the format is invalid
+}
+
+// next returns the next argument, or "" when all arguments have been used.
+func (p *printer) next() string {
+	if p.pos >= len(p.args) {
+		return ""
+	}
+	p.pos++
+	return p.args[p.pos-1]
+}
+
+// run formats the format string once. It reports whether output must stop,
+// because of a \c escape or an invalid directive.
+func (p *printer) run() bool {
+	f := p.format
+
+	for i := 0; i < len(f); i++ {
+		switch f[i] {
+		case '\\':
+			// Find the end of the escape sequence by expanding it alone.
+			j := i + 2
+			for j < len(f) && f[j] != '\\' && f[j] != '%' {
+				j++
+			}
+			text, stop := expandEscapes(f[i:min(j, len(f))], escFormat)
+			p.out.WriteString(text)
+			if stop {
+				return true
+			}
+			i = min(j, len(f)) - 1
+		case '%':
+			n, stop := p.directive(f[i+1:])
+			if stop {
+				return true
+			}
+			i += n
+		default:
+			p.out.WriteByte(f[i])
+		}
+	}
+
+	return false
+}
+
+// directive formats a single conversion starting right after the '%'. It
+// returns the number of bytes consumed and whether output must stop.
+func (p *printer) directive(f string) (int, bool) {
+	i := 0
+	for i < len(f) && strings.IndexByte("-+ #0", f[i]) >= 0 {
+		i++
+	}
+	flags := f[:i]
+
+	// Width and precision are numbers, or '*' to take them from an argument.
+	number := func() string {
+		if i < len(f) && f[i] == '*' {
+			i++
+			return strconv.FormatInt(p.number(p.next()), 10)
+		}
+		start := i
+		for i < len(f) && f[i] >= '0' && f[i] <= '9' {
+			i++
+		}
+		return f[start:i]
+	}
+
+	width := number()
+	if strings.HasPrefix(width, "-") {
+		flags, width = flags+"-", width[1:]
+	}
+	prec, hasPrec := "", false
+	if i < len(f) && f[i] == '.' {
+		i++
+		prec, hasPrec = number(), true
+		if prec == "" {
+			prec = "0"
+		}
+		if strings.HasPrefix(prec, "-") {
+			// A negative precision is taken as if it were omitted.
+			prec, hasPrec = "", false
+		}
+	}
+
+	if i == len(f) {
+		p.err = fmt.Errorf("printf: `%%%s': missing format character", f)
+		return i, true
+	}
+
+	spec := "%" + flags + width
+	if hasPrec {
+		spec += "." + prec
+	}
+
+	switch c := f[i]; c {
+	case '%':
+		p.out.WriteByte('%')
+	case 's':
+		p.out.WriteString(fmt.Sprintf(spec+"s", p.next()))
+	case 'b':
+		text, stop := expandEscapes(p.next(), escB)
+		p.out.WriteString(fmt.Sprintf(spec+"s", text))
+		if stop {
+			return i + 1, true
+		}
+	case 'q':
+		p.out.WriteString(fmt.Sprintf(spec+"s", quote(p.next())))
+	case 'c':
+		if arg := p.next(); arg != "" {
+			r, _ := utf8.DecodeRuneInString(arg)
+			p.out.WriteString(fmt.Sprintf("%"+flags+width+"c", r))
+		} else {
+			p.out.WriteString(fmt.Sprintf("%"+flags+width+"s", ""))
+		}
+	case 'd', 'i':
+		p.out.WriteString(fmt.Sprintf(spec+"d", p.number(p.next())))
+	case 'u', 'o', 'x', 'X':
+		verb := map[byte]string{'u': "d", 'o': "o", 'x': "x", 'X': "X"}[c]
+		p.out.WriteString(fmt.Sprintf(spec+verb, p.unsigned(p.next())))
+	case 'f', 'F', 'e', 'E', 'g', 'G':
+		if !hasPrec && (c == 'g' || c == 'G') {
+			// Like C, %g defaults to six significant digits.
+			spec += ".6"
+		}
+		p.out.WriteString(fmt.Sprintf(spec+string(c), p.float(p.next())))
+	default:
+		p.err = fmt.Errorf("printf: `%c': invalid format character", c)
+		return i + 1, true
+	}
+
+	return i + 1, false
+}
+
+// number converts an argument of a numeric conversion. Besides decimal,
+// octal (0755) and hexadecimal (0x1F) numbers, a leading quote gives the
+// code of the following character ('A is 65). Invalid numbers print a
+// warning, count as the valid prefix or 0, and make printf fail. Numbers out
+// of range are clamped to the largest or smallest int64, as C's strtol does.
+func (p *printer) number(arg string) int64 {
+	if arg == "" {
+		return 0
+	}
+	if arg[0] == '\'' || arg[0] == '"' {
+		r, _ := utf8.DecodeRuneInString(arg[1:])
+		if r == utf8.RuneError {
+			return 0
+		}
+		return int64(r)
+	}
+
+	s := strings.TrimSpace(arg)
+	n, err := strconv.ParseInt(s, 0, 64)
+	if err == nil {
+		return n
+	}
+	if errors.Is(err, strconv.ErrRange) {
+		p.tooLarge(arg)
+		return n // ParseInt returns the bound that was exceeded.
+	}
+
+	p.invalid(arg)
+
+	// Use the longest valid prefix, as C's strtol does, with the same
+	// hexadecimal and octal prefixes as a whole number.
+	for end := len(s) - 1; end > 0; end-- {
+		if n, err := strconv.ParseInt(s[:end], 0, 64); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+// unsigned converts an argument of an unsigned conversion (%u, %o, %x, %X),
+// which may go up to the largest uint64, like C's strtoumax. Negative
+// numbers wrap around.
+func (p *printer) unsigned(arg string) uint64 {
+	s := strings.TrimSpace(arg)
+	n, err := strconv.ParseUint(s, 0, 64)
+	if err == nil {
+		return n
+	}
+	if errors.Is(err, strconv.ErrRange) {
+		p.tooLarge(arg)
+		return n
+	}
+	return uint64(p.number(arg))
+}
+
+// float converts an argument of a floating-point conversion.
+func (p *printer) float(arg string) float64 {
+	if arg == "" {
+		return 0
+	}
+	if arg[0] == '\'' || arg[0] == '"' {
+		return float64(p.number(arg))
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(arg), 64)
+	if err != nil {
+		p.invalid(arg)
+	}
+	return f
+}
+
+// tooLarge warns about a number that does not fit in 64 bits.
+func (p *printer) tooLarge(arg string) {
+	_, _ = fmt.Fprintf(p.st.err, "shell: printf: %s: Result too large\n", arg)
+	p.failed = true
+}
+
+// invalid warns about an argument that is not a valid number.
+func (p *printer) invalid(arg string) {
+	_, _ = fmt.Fprintf(p.st.err, "shell: printf: %s: invalid number\n", arg)
+	p.failed = true
+}
@@ -1,33 +1,487 @@
 package shell
 
 import (
+	"cmp"
 	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/shirou/gopsutil/process"
 )
 
-// builtinPs lists all currently running processes, similar to the "ps" command.
-// It prints a simple table with PID and process name to standard output.
-func builtinPs() error {
-	// Retrieve all processes on the system using the gopsutil library.
-	procs, err := process.Processes()
+// procInfo is a snapshot of the attributes of a process shown by ps.
+type procInfo struct {
+	PID     int32
+	PPID    int32
+	User    string
+	CPU     float64 // CPU time used, as a percentage of the time since start
+	CPUTime float64 // user and system CPU time in seconds
+	Mem     float64 // resident memory, as a percentage of physical memory
+	RSS     uint64  // resident set size in KiB
+	State   string  // single-letter state: R, S, D, Z, T...
+	Start   time.Time
+	Name    string // executable name
+	Command string // full command line
+}
+
+// psColumn describes a column that ps can print and sort by.
+type psColumn struct {
+	key    string // stable field name in machine-readable output
+	header string
+	right  bool // right-aligned, for numbers
+	value  func(p *procInfo) string
+	raw    func(p *procInfo) any // typed value for machine-readable output
+	cmp    func(a, b *procInfo) int
+}
+
+// psColumns maps column names accepted by -o and --sort to columns.
+// Several names may refer to the same column, as in procps.
+var psColumns = map[string]*psColumn{}
+
+func init() {
+	columns := []struct {
+		names []string
+		col   *psColumn
+	}{
+		{[]string{"pid"}, &psColumn{key: "pid", header: "PID", right: true,
+			value: func(p *procInfo) string { return strconv.Itoa(int(p.PID)) },
+			raw:   func(p *procInfo) any { return p.PID },
+			cmp:   func(a, b *procInfo) int { return cmp.Compare(a.PID, b.PID) }}},
+		{[]string{"ppid"}, &psColumn{key: "ppid", header: "PPID", right: true,
+			value: func(p *procInfo) string { return strconv.Itoa(int(p.PPID)) },
+			raw:   func(p *procInfo) any { return p.PPID },
+			cmp:   func(a, b *procInfo) int { return cmp.Compare(a.PPID, b.PPID) }}},
+		{[]string{"user", "uname"}, &psColumn{key: "user", header: "USER",
+			value: func(p *procInfo) string { return p.User },
+			raw:   func(p *procInfo) any { return p.User },
+			cmp:   func(a, b *procInfo) int { return cmp.Compare(a.User, b.User) }}},
+		{[]string{"%cpu", "pcpu", "cpu"}, &psColumn{key: "cpu", header: "%CPU", right: true,
+			value: func(p *procInfo) string { return strconv.FormatFloat(p.CPU, 'f', 1, 64) },
+			raw:   func(p *procInfo) any { return p.CPU },
+			cmp:   func(a, b *procInfo) int { return cmp.Compare(a.CPU, b.CPU) }}},
+		{[]string{"%mem", "pmem", "mem"}, &psColumn{key: "mem", header: "%MEM", right: true,
+			value: func(p *procInfo) string { return strconv.FormatFloat(p.Mem, 'f', 1, 64) },
+			raw:   func(p *procInfo) any { return p.Mem },
+			cmp:   func(a, b *procInfo) int { return cmp.Compare(a.Mem, b.Mem) }}},
+		{[]string{"rss", "rssize"}, &psColumn{key: "rss", header: "RSS", right: true,
+			value: func(p *procInfo) string { return strconv.FormatUint(p.RSS, 10) },
+			raw:   func(p *procInfo) any { return p.RSS },
+			cmp:   func(a, b *procInfo) int { return cmp.Compare(a.RSS, b.RSS) }}},
+		{[]string{"state", "stat", "s"}, &psColumn{key: "state", header: "S",
+			value: func(p *procInfo) string { return p.State },
+			raw:   func(p *procInfo) any { return p.State },
+			cmp:   func(a, b *procInfo) int { return cmp.Compare(a.State, b.State) }}},
+		{[]string{"start", "stime", "start_time"}, &psColumn{key: "start", header: "START",
+			value: func(p *procInfo) string { return formatStart(p.Start) },
+			raw:   func(p *procInfo) any { return p.Start },
+			cmp:   func(a, b *procInfo) int { return a.Start.Compare(b.Start) }}},
+		{[]string{"comm", "ucomm", "name"}, &psColumn{key: "name", header: "CMD",
+			value: func(p *procInfo) string { return p.Name },
+			raw:   func(p *procInfo) any { return p.Name },
+			cmp:   func(a, b *procInfo) int { return cmp.Compare(a.Name, b.Name) }}},
+		{[]string{"args", "cmd", "command"}, &psColumn{key: "command", header: "COMMAND",
+			value: func(p *procInfo) string { return p.Command },
+			raw:   func(p *procInfo) any { return p.Command },
+			cmp:   func(a, b *procInfo) int { return cmp.Compare(a.Command, b.Command) }}},
+	}
+
+	for _, c := range columns {
+		for _, name := range c.names {
+			psColumns[name] = c.col
+		}
+	}
+}
+
+// Column lists of the default and the full (-f) output formats, and of the
+// machine-readable formats (--format), which include every column by default.
+const (
+	psDefaultColumns = "pid,comm"
+	psFullColumns    = "user,pid,ppid,%cpu,rss,stat,start,args"
+	psAllColumns     = "pid,ppid,user,%cpu,%mem,rss,stat,start,comm,args"
+)
+
+// formatStart formats a start time like procps: the time of day for
+// processes started today, the date otherwise.
+func formatStart(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	if now := time.Now(); t.YearDay() == now.YearDay() && t.Year() == now.Year() {
+		return t.Format("15:04")
+	}
+	return t.Format("Jan02")
+}
+
+// psField is a column selected for output, with its header.
+type psField struct {
+	col    *psColumn
+	header string
+}
+
+// psSortKey is a column to sort by, in ascending or descending order.
+type psSortKey struct {
+	col  *psColumn
+	desc bool
+}
+
+// psOptions holds the parsed arguments of ps. Selection options narrow
+// the list down: a process is shown only if it matches all of them.
+type psOptions struct {
+	fields   []psField
+	noHeader bool
+	format   string // "table", or a machine-readable format: "json", "csv" or "tsv"
+	sort     []psSortKey
+
+	users    []string // -u: user names
+	pids     []int32  // -p: process IDs
+	names    []string // -C: patterns matched against the process name
+	ppids    []int32  // --ppid: parent process IDs
+	children bool     // --children: children of the shell
+
+	tree     bool  // --tree: show the process tree
+	treeRoot int32 // --tree=ROOT: only show the subtree of this process
+}
+
+// builtinPs lists running processes, similar to the "ps" command.
+// By default it prints the PID and name of every process; options select the
+// columns (-o, -f), filter the processes (-u, -p, -C, --ppid, --children),
+// sort them (--sort), show them as a tree (--tree) and choose a
+// machine-readable format (--format).
+func (s *Shell) builtinPs(args []string, st *stdio) error {
+	opts, err := parsePsArgs(args)
+	if err != nil {
+		return fmt.Errorf("ps: %w", err)
+	}
+
+	procs, err := listProcesses()
 	if err != nil {
-		return err
+		return fmt.Errorf("ps: %w", err)
+	}
+
+	// The processes started by the shell are highlighted in the tree view.
+	own := descendants(procs, int32(os.Getpid()))
+	if opts.treeRoot != 0 {
+		subtree := descendants(procs, opts.treeRoot)
+		if !slices.ContainsFunc(procs, func(p *procInfo) bool { return p.PID == opts.treeRoot }) {
+			return fmt.Errorf("ps: %d: no such process", opts.treeRoot)
+		}
+		procs = slices.DeleteFunc(procs, func(p *procInfo) bool { return !subtree[p.PID] })
+	}
+
+	procs = slices.DeleteFunc(procs, func(p *procInfo) bool { return !opts.match(p) })
+	opts.sortProcs(procs)
+
+	if opts.format == "table" {
+		var tree *psTree
+		if opts.tree {
+			procs, tree = buildTree(procs, own)
+		}
+		for _, line := range formatPsTable(opts, procs, tree) {
+			_, _ = fmt.Fprintln(st.out, line)
+		}
+		return nil
+	}
+	return printPsRecords(st, opts, procs)
+}
+
+// parsePsArgs parses the arguments of ps. Option values may be attached
+// (-opid) or separate (-o pid); lists are separated by commas or blanks.
+func parsePsArgs(args []string) (*psOptions, error) {
+	opts := &psOptions{format: "table"}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		// value returns the argument of an option, attached or following it.
+		value := func(opt string) (string, error) {
+			if rest := strings.TrimPrefix(arg, opt); rest != "" {
+				return strings.TrimPrefix(rest, "="), nil
+			}
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("%s: option requires an argument", opt)
+			}
+			i++
+			return args[i], nil
+		}
+
+		var (
+			v   string
+			err error
+		)
+		switch {
+		case arg == "-e" || arg == "-A":
+			// All processes are shown by default.
+		case arg == "-f":
+			err = opts.addFields(psFullColumns)
+		case arg == "--no-headers" || arg == "--no-header":
+			opts.noHeader = true
+		case arg == "--children":
+			opts.children = true
+		case arg == "--tree":
+			opts.tree = true
+		case strings.HasPrefix(arg, "--tree="):
+			opts.tree = true
+			opts.treeRoot, err = parseTreeRoot(arg[len("--tree="):])
+		case strings.HasPrefix(arg, "-o"):
+			if v, err = value("-o"); err == nil {
+				err = opts.addFields(v)
+			}
+		case strings.HasPrefix(arg, "-u"), strings.HasPrefix(arg, "-U"):
+			if v, err = value(arg[:2]); err == nil {
+				opts.users = append(opts.users, splitList(v)...)
+			}
+		case strings.HasPrefix(arg, "-C"):
+			if v, err = value("-C"); err == nil {
+				opts.names = append(opts.names, splitList(v)...)
+			}
+		case strings.HasPrefix(arg, "-p"):
+			if v, err = value("-p"); err == nil {
+				opts.pids, err = appendPids(opts.pids, v)
+			}
+		case strings.HasPrefix(arg, "--ppid"):
+			if v, err = value("--ppid"); err == nil {
+				opts.ppids, err = appendPids(opts.ppids, v)
+			}
+		case strings.HasPrefix(arg, "--format"):
+			if v, err = value("--format"); err == nil {
+				if !slices.Contains([]string{"table", "json", "csv", "tsv"}, v) {
+					err = fmt.Errorf("%s: unknown format", v)
+				}
+				opts.format = v
+			}
+		case strings.HasPrefix(arg, "--sort"):
+			if v, err = value("--sort"); err == nil {
+				err = opts.addSortKeys(v)
+			}
+		default:
+			err = fmt.Errorf("%s: invalid option", arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.tree && opts.format != "table" {
+		return nil, fmt.Errorf("--tree cannot be used with --format %s", opts.format)
 	}
 
-	// Print table header with column names.
-	fmt.Printf("%6s %s\n", "PID", "CMD")
+	if opts.fields == nil {
+		if opts.format == "table" {
+			_ = opts.addFields(psDefaultColumns)
+		} else {
+			_ = opts.addFields(psAllColumns)
+		}
+	}
+
+	return opts, nil
+}
 
+// splitList splits a list separated by commas or blanks.
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// appendPids parses a list of process IDs.
+func appendPids(pids []int32, list string) ([]int32, error) {
+	for _, f := range splitList(list) {
+		n, err := strconv.ParseInt(f, 10, 32)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%s: invalid process ID", f)
+		}
+		pids = append(pids, int32(n))
+	}
+	return pids, nil
+}
+
+// addFields adds output columns. A column may be given a custom header
+// with "name=HEADER".
+func (o *psOptions) addFields(list string) error {
+	for _, f := range splitList(list) {
+		name, header, _ := strings.Cut(f, "=")
+		col, ok := psColumns[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("%s: unknown column", name)
+		}
+		if header == "" {
+			header = col.header
+		}
+		o.fields = append(o.fields, psField{col: col, header: header})
+	}
+	return nil
+}
+
+// addSortKeys adds sort keys such as "-%cpu" (descending) or "+pid".
+func (o *psOptions) addSortKeys(list string) error {
+	for _, k := range splitList(list) {
+		desc := strings.HasPrefix(k, "-")
+		name := strings.TrimLeft(k, "+-")
+		col, ok := psColumns[strings.ToLower(name)]
+		if !ok {
+			return fmt.Errorf("%s: unknown sort key", name)
+		}
+		o.sort = append(o.sort, psSortKey{col: col, desc: desc})
+	}
+	return nil
+}
+
+// match reports whether a process passes all selection options.
+func (o *psOptions) match(p *procInfo) bool {
+	if len(o.pids) > 0 && !slices.Contains(o.pids, p.PID) {
+		return false
+	}
+	if len(o.ppids) > 0 && !slices.Contains(o.ppids, p.PPID) {
+		return false
+	}
+	if o.children && int(p.PPID) != os.Getpid() {
+		return false
+	}
+	if len(o.users) > 0 && !slices.Contains(o.users, p.User) {
+		return false
+	}
+	if len(o.names) > 0 && !slices.ContainsFunc(o.names, func(pat string) bool {
+		return matchPattern(pat, p.Name, false)
+	}) {
+		return false
+	}
+	return true
+}
+
+// sortProcs sorts processes by the sort keys, then by PID.
+func (o *psOptions) sortProcs(procs []*procInfo) {
+	slices.SortStableFunc(procs, func(a, b *procInfo) int {
+		for _, k := range o.sort {
+			c := k.col.cmp(a, b)
+			if k.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(a.PID, b.PID)
+	})
+}
+
+// formatPsTable formats the processes as the lines of a table with aligned
+// columns, starting with the header unless it is disabled. The last column is
+// not padded, so that long command lines are kept whole. In the tree view the
+// last column is indented to show the tree, and the processes of the shell
+// are marked with a '*' in a leading gutter. Control characters, such as the
+// newlines of a command line, are shown as spaces to keep one line per process.
+func formatPsTable(opts *psOptions, procs []*procInfo, tree *psTree) []string {
+	rows := make([][]string, 0, len(procs)+1)
+	if !opts.noHeader {
+		header := make([]string, len(opts.fields))
+		for i, f := range opts.fields {
+			header[i] = f.header
+		}
+		rows = append(rows, header)
+	}
 	for _, p := range procs {
-		// Get the name of the process.
+		row := make([]string, len(opts.fields))
+		for i, f := range opts.fields {
+			row[i] = oneLine(f.col.value(p))
+		}
+		if tree != nil {
+			row[len(row)-1] = tree.indent[p.PID] + row[len(row)-1]
+		}
+		rows = append(rows, row)
+	}
+
+	widths := make([]int, len(opts.fields))
+	for _, row := range rows {
+		for i, v := range row {
+			widths[i] = max(widths[i], len([]rune(v)))
+		}
+	}
+
+	lines := make([]string, 0, len(rows))
+	header := len(rows) - len(procs)
+	for j, row := range rows {
+		var b strings.Builder
+		if tree != nil {
+			if j >= header && tree.own[procs[j-header].PID] {
+				b.WriteByte('*')
+			} else {
+				b.WriteByte(' ')
+			}
+		}
+		for i, v := range row {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			pad := strings.Repeat(" ", widths[i]-len([]rune(v)))
+			switch {
+			case opts.fields[i].col.right:
+				b.WriteString(pad + v)
+			case i < len(row)-1:
+				b.WriteString(v + pad)
+			default:
+				b.WriteString(v)
+			}
+		}
+		lines = append(lines, b.String())
+	}
+
+	return lines
+}
+
+// listProcesses collects information about all running processes using the
+// gopsutil library. Processes that exit while being inspected are skipped;
+// attributes that cannot be read (e.g. for lack of permissions) are left empty.
+func listProcesses() ([]*procInfo, error) {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*procInfo, 0, len(procs))
+	for _, p := range procs {
+		// Get the name of the process; failing that, it is gone.
 		name, err := p.Name()
 		if err != nil {
 			continue
 		}
 
-		// Print the process PID and name
-		// %6d ensures PID is right-aligned in 6-character width for neat formatting.
-		fmt.Printf("%6d %s\n", p.Pid, name)
+		info := &procInfo{PID: p.Pid, Name: name, User: "?", State: "?"}
+		info.PPID, _ = p.Ppid()
+		if user, err := p.Username(); err == nil {
+			info.User = user
+		} else if uids, err := p.Uids(); err == nil && len(uids) > 0 {
+			info.User = strconv.Itoa(int(uids[0]))
+		}
+		if times, err := p.Times(); err == nil {
+			info.CPUTime = times.User + times.System
+		}
+		if mem, err := p.MemoryPercent(); err == nil {
+			info.Mem = float64(mem)
+		}
+		if mem, err := p.MemoryInfo(); err == nil {
+			info.RSS = mem.RSS / 1024
+		}
+		if state, err := p.Status(); err == nil && state != "" {
+			info.State = state
+		}
+		if ms, err := p.CreateTime(); err == nil {
+			info.Start = time.UnixMilli(ms)
+			if elapsed := time.Since(info.Start).Seconds(); elapsed > 0 {
+				info.CPU = 100 * info.CPUTime / elapsed
+			}
+		}
+
+		// Kernel threads have no command line; show their name in brackets.
+		info.Command, _ = p.Cmdline()
+		if info.Command == "" {
+			info.Command = "[" + name + "]"
+		}
+
+		infos = append(infos, info)
 	}
-	return nil
+
+	return infos, nil
 }
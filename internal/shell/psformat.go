@@ -0,0 +1,116 @@
+package shell
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// printPsRecords prints one record per process in a machine-readable format:
+//
+//   - json: one JSON object per line, with the fields in column order;
+//   - csv: comma-separated values, with quoting where needed;
+//   - tsv: tab-separated values, with tabs, newlines and other control characters in values replaced by spaces.
+//
+// Fields are named by their stable key (pid, ppid, user, cpu, mem, rss, state,
+// start, name, command) whatever the column was called in -o. CSV and TSV
+// output starts with a header line of these keys unless --no-headers is given.
+// Start times use RFC 3339.
+func printPsRecords(st *stdio, opts *psOptions, procs []*procInfo) error {
+	if opts.format == "json" {
+		for _, p := range procs {
+			var b strings.Builder
+			b.WriteByte('{')
+			for i, f := range opts.fields {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				key, _ := json.Marshal(f.col.key)
+				value, err := json.Marshal(jsonValue(f.col.raw(p)))
+				if err != nil {
+					return fmt.Errorf("ps: %w", err)
+				}
+				b.Write(key)
+				b.WriteByte(':')
+				b.Write(value)
+			}
+			b.WriteByte('}')
+			_, _ = fmt.Fprintln(st.out, b.String())
+		}
+		return nil
+	}
+
+	// TSV is written by hand, as it does not quote values like CSV does.
+	w := csv.NewWriter(st.out)
+	write := func(record []string) {
+		if opts.format == "csv" {
+			_ = w.Write(record)
+			return
+		}
+		_, _ = fmt.Fprintln(st.out, strings.Join(record, "\t"))
+	}
+
+	record := make([]string, len(opts.fields))
+	if !opts.noHeader {
+		for i, f := range opts.fields {
+			record[i] = f.col.key
+		}
+		write(record)
+	}
+	for _, p := range procs {
+		for i, f := range opts.fields {
+			record[i] = recordValue(f.col.raw(p))
+			if opts.format == "tsv" {
+				record[i] = oneLine(record[i])
+			}
+		}
+		write(record)
+	}
+
+	w.Flush()
+	return nil
+}
+
+// jsonValue converts a field value for JSON output: times become RFC 3339
+// strings, or null if unknown.
+func jsonValue(v any) any {
+	if t, ok := v.(time.Time); ok {
+		if t.IsZero() {
+			return nil
+		}
+		return t.Format(time.RFC3339)
+	}
+	return v
+}
+
+// recordValue formats a field value for CSV and TSV output.
+func recordValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', 2, 64)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// oneLine replaces the tabs, newlines and other control characters of a
+// value by spaces, so that it fits on its line of a table or TSV record.
+func oneLine(v string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, v)
+}
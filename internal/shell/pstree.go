@@ -0,0 +1,99 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// psTree describes the tree view of ps (--tree): the order in which the
+// processes are printed, the tree drawing to put before each command, and
+// the processes that belong to the shell.
+type psTree struct {
+	indent map[int32]string
+	own    map[int32]bool
+}
+
+// parseTreeRoot parses the argument of --tree=ROOT: a PID, or "self" for the shell.
+func parseTreeRoot(v string) (int32, error) {
+	if v == "self" || v == "shell" {
+		return int32(os.Getpid()), nil
+	}
+	n, err := strconv.ParseInt(v, 10, 32)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s: invalid process ID", v)
+	}
+	return int32(n), nil
+}
+
+// descendants returns the process root and all its descendants.
+func descendants(procs []*procInfo, root int32) map[int32]bool {
+	children := make(map[int32][]int32)
+	for _, p := range procs {
+		children[p.PPID] = append(children[p.PPID], p.PID)
+	}
+
+	set := map[int32]bool{root: true}
+	queue := []int32{root}
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		for _, c := range children[pid] {
+			if !set[c] {
+				set[c] = true
+				queue = append(queue, c)
+			}
+		}
+	}
+	return set
+}
+
+// buildTree arranges the processes, already sorted, as a tree: each process
+// is followed by its children, in the same order. A process whose parent is
+// not in the list is a root. It returns the processes in tree order.
+func buildTree(procs []*procInfo, own map[int32]bool) ([]*procInfo, *psTree) {
+	present := make(map[int32]bool, len(procs))
+	for _, p := range procs {
+		present[p.PID] = true
+	}
+
+	children := make(map[int32][]*procInfo)
+	var roots []*procInfo
+	for _, p := range procs {
+		if p.PPID != p.PID && present[p.PPID] {
+			children[p.PPID] = append(children[p.PPID], p)
+		} else {
+			roots = append(roots, p)
+		}
+	}
+
+	tree := &psTree{indent: make(map[int32]string, len(procs)), own: own}
+	ordered := make([]*procInfo, 0, len(procs))
+
+	var walk func(p *procInfo, prefix, branch string)
+	walk = func(p *procInfo, prefix, branch string) {
+		ordered = append(ordered, p)
+		tree.indent[p.PID] = prefix + branch
+
+		kids := children[p.PID]
+		for i, c := range kids {
+			next := prefix
+			switch branch {
+			case "├─ ":
+				next += "│  "
+			case "└─ ":
+				next += "   "
+			}
+			if i == len(kids)-1 {
+				walk(c, next, "└─ ")
+			} else {
+				walk(c, next, "├─ ")
+			}
+		}
+	}
+	for _, r := range roots {
+		walk(r, "", "")
+	}
+
+	return ordered, tree
+}
@@ -1,21 +1,103 @@
 package shell
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
 )
 
-// buildinPWD prints the current working directory to standard output,
-// similar to the "pwd" command in Unix shells.
-func buildinPWD() error {
+// ErrDirRemoved is reported when the current working directory no longer
+// exists, e.g. because it was deleted from another terminal.
+var ErrDirRemoved = errors.New("current directory has been removed")
+
+// buildinPWD implements "pwd [-L|-P]": print the current working directory
+// to standard output, similar to the "pwd" command in Unix shells. By default
+// (-L) the logical path maintained by cd is printed, which still works after
+// the directory was removed; -P prints the path with symbolic links resolved.
+func (s *Shell) buildinPWD(args []string, st *stdio) error {
+	physical := false
+	for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+		if args[0] == "--" {
+			args = args[1:]
+			break
+		}
+		if strings.Trim(args[0][1:], "LP") != "" {
+			return fmt.Errorf("pwd: %s: invalid option", args[0])
+		}
+		// The last of -L and -P wins.
+		physical = args[0][len(args[0])-1] == 'P'
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("pwd: %w", ErrTooManyArguments)
+	}
+
 	// Retrieve the current working directory.
-	dir, err := os.Getwd()
+	var dir string
+	var err error
+	if physical {
+		dir, err = s.physicalDir()
+	} else if dir, err = s.WorkingDir(); dir != "" {
+		err = nil
+	}
 	if err != nil {
 		return fmt.Errorf("pwd: cannot get current directory: %w", err)
 	}
 
 	// Print the current working directory.
-	fmt.Println(dir)
+	_, _ = fmt.Fprintln(st.out, dir)
 
 	return nil
 }
+
+// WorkingDir returns the logical current working directory: $PWD if it is an
+// absolute path naming the current directory, otherwise the physical one.
+// If the current directory has been removed, it returns the last known path
+// ($PWD, possibly empty) together with ErrDirRemoved.
+func (s *Shell) WorkingDir() (string, error) {
+	if s.dir != "" {
+		return s.dir, nil
+	}
+
+	pwd, ok := s.LookupVar("PWD")
+	ok = ok && filepath.IsAbs(pwd)
+
+	if ok {
+		a, errA := os.Stat(pwd)
+		b, errB := os.Stat(".")
+		if errA == nil && errB == nil && os.SameFile(a, b) {
+			return filepath.Clean(pwd), nil
+		}
+	}
+
+	dir, err := s.physicalDir()
+	if errors.Is(err, syscall.ENOENT) {
+		if !ok {
+			pwd = ""
+		}
+		return pwd, ErrDirRemoved
+	}
+	return dir, err
+}
+
+// physicalDir returns the current working directory with all symbolic links
+// resolved. Unlike os.Getwd, it never returns $PWD.
+func (s *Shell) physicalDir() (string, error) {
+	if s.dir != "" {
+		return filepath.EvalSymlinks(s.dir)
+	}
+	return syscall.Getwd()
+}
+
+// path resolves a relative file name against the working directory of a
+// pipeline stage. In the main shell it is returned unchanged, as the
+// process's working directory is the shell's.
+func (s *Shell) path(name string) string {
+	if s.dir == "" || name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(s.dir, name)
+}
@@ -0,0 +1,78 @@
+package shell
+
+import (
+	"fmt"
+)
+
+// shellOptions lists the options that "set -o" knows, in the order they are listed.
+var shellOptions = []string{"emacs", "histexpand", "vi"}
+
+// Option reports whether a shell option, such as "vi", is enabled.
+func (s *Shell) Option(name string) bool {
+	return s.options[name]
+}
+
+// setOption enables or disables a shell option. The editing modes "emacs"
+// and "vi" exclude each other: disabling one enables the other. "histexpand"
+// controls history expansion (see ExpandHistory).
+func (s *Shell) setOption(name string, on bool) error {
+	switch name {
+	case "emacs", "vi":
+		other := map[string]string{"emacs": "vi", "vi": "emacs"}[name]
+		s.options[name], s.options[other] = on, !on
+	case "histexpand":
+		s.options[name] = on
+	default:
+		return fmt.Errorf("%s: invalid option name", name)
+	}
+	return nil
+}
+
+// builtinSet implements "set -o option" and "set +o option", which enable
+// and disable shell options. "set -o" alone lists the options and their
+// state, "set +o" prints them as the commands that restore that state.
+func (s *Shell) builtinSet(args []string, st *stdio) error {
+	if len(args) == 0 {
+		return fmt.Errorf("set: usage: set [-o | +o] [option]")
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg != "-o" && arg != "+o" {
+			return fmt.Errorf("set: %s: invalid option", arg)
+		}
+		on := arg == "-o"
+
+		if i+1 == len(args) {
+			s.printOptions(st, on)
+			break
+		}
+		i++
+		if err := s.setOption(args[i], on); err != nil {
+			return fmt.Errorf("set: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// printOptions lists the shell options, as a table or as set commands.
+func (s *Shell) printOptions(st *stdio, table bool) {
+	for _, name := range shellOptions {
+		on := s.options[name]
+		if table {
+			state := "off"
+			if on {
+				state = "on"
+			}
+			_, _ = fmt.Fprintf(st.out, "%-15s\t%s\n", name, state)
+			continue
+		}
+
+		flag := "+o"
+		if on {
+			flag = "-o"
+		}
+		_, _ = fmt.Fprintf(st.out, "set %s %s\n", flag, name)
+	}
+}
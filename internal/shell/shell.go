@@ -1,22 +1,113 @@
 package shell
 
+import (
+	"errors"
+	"maps"
+	"os"
+	"slices"
+)
+
+// Shell holds the state of a shell session: variables, functions, positional
+// parameters and the status of the last command.
 type Shell struct {
+	name        string               // shell name, reported as $0
+	vars        map[string]string    // shell variables that are not in the environment
+	arrays      map[string][]string  // indexed arrays, such as BASH_REMATCH
+	scopes      []scope              // local variables of the running function calls
+	funcs       map[string]*Command  // shell functions by name
+	aliases     map[string]string    // aliases by name
+	compSpecs   map[string]*compSpec // completion specs of complete, by command name
+	dirs        []string             // directory stack of pushd and popd, below the current directory
+	options     map[string]bool      // shell options of set -o, such as vi
+	history     []histEntry          // command history, oldest first
+	histBase    int                  // number of commands dropped from the start of the history
+	histOn      bool                 // whether ExecuteLine adds the commands to the history
+	interactive bool                 // whether commands are typed at a terminal; only then cd records directories for z
+
+	lastSubst histSubst // last substitution of history expansion, for :&
+	params    []string  // positional parameters ($1, $2, ...)
+	status    int       // exit status of the last pipeline ($?)
+	loops     int       // number of enclosing loops, for break and continue
+
+	// A pipeline stage runs next to the other stages in the same process, so
+	// it keeps its own environment and working directory instead of changing
+	// the process's. Both are unset in the main shell.
+	env map[string]string // environment of a pipeline stage
+	dir string            // working directory of a pipeline stage
+
+	script   string // name of the file being sourced, for error messages
+	lineno   int    // line number of the running pipeline in that file
+	sourcing int    // depth of nested source commands, for return
 }
 
 func New() *Shell {
-	return &Shell{}
+	return &Shell{
+		name:      "minishell",
+		vars:      make(map[string]string),
+		arrays:    make(map[string][]string),
+		funcs:     make(map[string]*Command),
+		aliases:   make(map[string]string),
+		compSpecs: make(map[string]*compSpec),
+		options:   map[string]bool{"emacs": true, "histexpand": true},
+	}
+}
+
+// SetInteractive marks the session as interactive, reading the commands
+// typed by a user. Only the directories entered in interactive sessions are
+// recorded in the frecency database of z, not those of scripts.
+func (s *Shell) SetInteractive() {
+	s.interactive = true
 }
 
-// ExecuteLine parses a single line of shell input and executes it.
-// It first converts the line into a Pipeline (commands, pipes, conditionals)
-// and then runs the pipeline.
+// subshell returns a copy of the shell for a command that runs in a subshell,
+// such as a builtin or a function in a pipeline: what it changes is lost when
+// it ends. The history is not recorded. It gets its own copy of the
+// environment and of the working directory, as cd, export and unset in one
+// stage must not affect the others.
+func (s *Shell) subshell() *Shell {
+	sub := *s
+	sub.vars = maps.Clone(s.vars)
+	sub.arrays = maps.Clone(s.arrays)
+	sub.funcs = maps.Clone(s.funcs)
+	sub.aliases = maps.Clone(s.aliases)
+	sub.compSpecs = maps.Clone(s.compSpecs)
+	sub.options = maps.Clone(s.options)
+	sub.dirs = slices.Clone(s.dirs)
+	sub.history = slices.Clip(s.history)
+	sub.histOn = false
+	sub.params = slices.Clip(s.params)
+	sub.env = s.environMap()
+	if sub.dir == "" {
+		if sub.dir, _ = s.WorkingDir(); sub.dir == "" {
+			sub.dir = "."
+		}
+	}
+	sub.scopes = make([]scope, len(s.scopes))
+	for i, sc := range s.scopes {
+		sub.scopes[i] = maps.Clone(sc)
+	}
+	return &sub
+}
+
+// ExecuteLine parses shell input and executes it.
+// It first converts the input into a List of pipelines (commands, pipes,
+// conditionals, compound commands) and then runs them in order.
+// If the input is incomplete (e.g. an unterminated if or quote), the returned
+// error wraps ErrIncomplete and nothing is executed. Once the history is
+// enabled with LoadHistory, complete input is added to it.
 func (s *Shell) ExecuteLine(line string) error {
-	// Parse the line into a Pipeline structure.
-	p, err := Parse(line)
+	// A Ctrl+C pressed before the line started does not stop it.
+	interrupted.Store(false)
+
+	// Parse the input into a List structure.
+	l, err := parse(line, 1, s.aliases)
+	if s.histOn && !errors.Is(err, ErrIncomplete) {
+		s.addHistory(line)
+	}
 	if err != nil {
 		return err
 	}
 
-	// Execute the parsed pipeline.
-	return s.Run(p)
+	// Execute the parsed list with the shell's own standard streams.
+	return s.runList(l, &stdio{in: os.Stdin, out: os.Stdout, err: os.Stderr})
 }
@@ -0,0 +1,138 @@
+package shell
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Source executes the commands of a file in the current shell, as the
+// "source" builtin does. Errors are reported with the file name and line number.
+func (s *Shell) Source(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	s.sourcing++
+	defer func() { s.sourcing-- }()
+
+	return s.runScript(path, f, &stdio{in: os.Stdin, out: os.Stdout, err: os.Stderr})
+}
+
+// builtinSource implements "source file [args]" and ". file [args]": execute
+// the commands of a file in the current shell, with args as positional parameters.
+// A file name without a slash is looked up in PATH, then in the current directory.
+func (s *Shell) builtinSource(args []string, st *stdio) error {
+	if len(args) == 0 {
+		return fmt.Errorf("source: filename argument required")
+	}
+
+	path := s.findSourceFile(args[0])
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("source: %s: %w", args[0], errors.Unwrap(err))
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	// Extra arguments replace the positional parameters while the file runs.
+	if len(args) > 1 {
+		params := s.params
+		s.params = args[1:]
+		defer func() { s.params = params }()
+	}
+
+	s.sourcing++
+	defer func() { s.sourcing-- }()
+
+	return s.runScript(args[0], f, st)
+}
+
+// findSourceFile resolves the file name given to source.
+func (s *Shell) findSourceFile(name string) string {
+	if strings.Contains(name, "/") {
+		return s.path(name)
+	}
+
+	path, _ := s.lookupEnv("PATH")
+	for _, dir := range filepath.SplitList(path) {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			return path
+		}
+	}
+
+	return s.path(name)
+}
+
+// runScript reads commands from r and executes them one complete command at a
+// time, so that definitions take effect before the following lines are parsed.
+// name is used in error messages, together with the line number.
+// "return" at the top level of the script stops it.
+func (s *Shell) runScript(name string, r io.Reader, st *stdio) error {
+	script, lineno := s.script, s.lineno
+	s.script = name
+	defer func() { s.script, s.lineno = script, lineno }()
+
+	reader := bufio.NewReader(r)
+	var chunk strings.Builder // lines of the command being read
+	start, n := 0, 0          // first line of the chunk, current line
+	var result error
+
+	for {
+		line, err := reader.ReadString('\n')
+		if line == "" && err != nil {
+			break
+		}
+		n++
+
+		if chunk.Len() == 0 {
+			start = n
+		}
+		chunk.WriteString(strings.TrimSuffix(line, "\n"))
+		chunk.WriteString("\n")
+
+		l, perr := parse(chunk.String(), start, s.aliases)
+		if errors.Is(perr, ErrIncomplete) {
+			// The command continues on the next line.
+			continue
+		}
+		chunk.Reset()
+
+		if perr != nil {
+			s.lineno = n
+			_ = s.report(perr)
+			return &StatusError{Code: 2}
+		}
+
+		result = s.runList(l, st)
+
+		var ret *funcReturn
+		if errors.As(result, &ret) {
+			if ret.code == 0 {
+				return nil
+			}
+			return &StatusError{Code: ret.code}
+		}
+		if isControl(result) {
+			return result
+		}
+	}
+
+	if chunk.Len() > 0 {
+		s.lineno = n
+		_ = s.report(fmt.Errorf("syntax error: unexpected end of file"))
+		return &StatusError{Code: 2}
+	}
+
+	return result
+}
@@ -0,0 +1,320 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/aliskhannn/minishell/internal/term"
+)
+
+// errTestSyntax marks malformed test expressions, which exit with status 2.
+var errTestSyntax = errors.New("syntax error")
+
+// unaryTests lists the unary operators understood by test, [ and [[.
+var unaryTests = map[string]bool{
+	"-a": true, "-b": true, "-c": true, "-d": true, "-e": true, "-f": true,
+	"-g": true, "-h": true, "-k": true, "-L": true, "-n": true, "-O": true,
+	"-G": true, "-p": true, "-r": true, "-s": true, "-S": true, "-t": true,
+	"-u": true, "-v": true, "-w": true, "-x": true, "-z": true,
+}
+
+// binaryTests lists the binary operators understood by test and [.
+var binaryTests = map[string]bool{
+	"=": true, "==": true, "!=": true, "<": true, ">": true,
+	"-eq": true, "-ne": true, "-lt": true, "-le": true, "-gt": true, "-ge": true,
+	"-nt": true, "-ot": true, "-ef": true,
+}
+
+// builtinTest implements "test expr": evaluate a conditional expression and
+// succeed if it is true. Supported are file tests (-e, -f, -d, -x, -nt...),
+// string tests and comparisons, integer comparisons, "!", "-a", "-o" and parentheses.
+func (s *Shell) builtinTest(args []string, _ *stdio) error {
+	return s.runTest("test", args)
+}
+
+// builtinBracket implements "[ expr ]", the same as test with a closing bracket.
+func (s *Shell) builtinBracket(args []string, _ *stdio) error {
+	if len(args) == 0 || args[len(args)-1] != "]" {
+		return &testError{msg: "[: missing `]'"}
+	}
+	return s.runTest("[", args[:len(args)-1])
+}
+
+// testError reports a malformed expression or operand; it exits with status 2.
+type testError struct {
+	msg string
+}
+
+func (e *testError) Error() string {
+	return e.msg
+}
+
+// ExitCode returns the exit status of a malformed test.
+func (e *testError) ExitCode() int {
+	return 2
+}
+
+// runTest evaluates a test expression and converts the result to a status.
+func (s *Shell) runTest(name string, args []string) error {
+	t := &testParser{s: s, args: args}
+
+	ok, err := t.or()
+	if err == nil && t.pos < len(args) {
+		err = fmt.Errorf("%s: %w", args[t.pos], errTestSyntax)
+	}
+	if err != nil {
+		return testFailure(name, err)
+	}
+
+	if !ok {
+		return &StatusError{Code: 1}
+	}
+	return nil
+}
+
+// testFailure turns an evaluation error into a reported error with status 2.
+func testFailure(name string, err error) error {
+	var te *testError
+	if errors.As(err, &te) {
+		return err
+	}
+	return &testError{msg: name + ": " + err.Error()}
+}
+
+// testParser evaluates the arguments of test by recursive descent:
+// "-o" binds looser than "-a", which binds looser than "!".
+type testParser struct {
+	s    *Shell
+	args []string
+	pos  int
+}
+
+// peek returns the argument at offset n from the current position, or "".
+func (t *testParser) peek(n int) (string, bool) {
+	if t.pos+n < len(t.args) {
+		return t.args[t.pos+n], true
+	}
+	return "", false
+}
+
+// or evaluates "expr -o expr".
+func (t *testParser) or() (bool, error) {
+	ok, err := t.and()
+	for err == nil {
+		if a, _ := t.peek(0); a != "-o" {
+			break
+		}
+		t.pos++
+
+		var r bool
+		if r, err = t.and(); err == nil {
+			ok = ok || r
+		}
+	}
+	return ok, err
+}
+
+// and evaluates "expr -a expr".
+func (t *testParser) and() (bool, error) {
+	ok, err := t.not()
+	for err == nil {
+		if a, _ := t.peek(0); a != "-a" {
+			break
+		}
+		t.pos++
+
+		var r bool
+		if r, err = t.not(); err == nil {
+			ok = ok && r
+		}
+	}
+	return ok, err
+}
+
+// not evaluates "! expr".
+func (t *testParser) not() (bool, error) {
+	if a, _ := t.peek(0); a == "!" && t.pos+1 < len(t.args) {
+		t.pos++
+		ok, err := t.not()
+		return !ok, err
+	}
+	return t.primary()
+}
+
+// primary evaluates a parenthesized expression, a binary or unary test, or a
+// single string (true if non-empty).
+func (t *testParser) primary() (bool, error) {
+	a, ok := t.peek(0)
+	if !ok {
+		if t.pos == 0 {
+			return false, nil // "test" without arguments is false
+		}
+		return false, fmt.Errorf("argument expected")
+	}
+
+	// A binary operator takes precedence, so that "test -f = -f" compares strings.
+	if op, ok := t.peek(1); ok && binaryTests[op] {
+		if r, ok := t.peek(2); ok {
+			t.pos += 3
+			return t.s.testBinary(op, a, r)
+		}
+	}
+
+	if a == "(" {
+		t.pos++
+		v, err := t.or()
+		if err != nil {
+			return false, err
+		}
+		if r, _ := t.peek(0); r != ")" {
+			return false, fmt.Errorf("`)' expected")
+		}
+		t.pos++
+		return v, nil
+	}
+
+	if unaryTests[a] {
+		if arg, ok := t.peek(1); ok {
+			t.pos += 2
+			return t.s.testUnary(a, arg)
+		}
+	}
+
+	t.pos++
+	return a != "", nil
+}
+
+// testUnary evaluates a unary test such as "-f file" or "-z string".
+func (s *Shell) testUnary(op, arg string) (bool, error) {
+	switch op {
+	case "-n":
+		return arg != "", nil
+	case "-z":
+		return arg == "", nil
+	case "-v":
+		_, ok := s.param(arg)
+		return ok, nil
+	case "-t":
+		fd, err := strconv.Atoi(arg)
+		if err != nil {
+			return false, fmt.Errorf("%s: integer expression expected", arg)
+		}
+		return term.IsTerminal(fd), nil
+	case "-r", "-w", "-x":
+		mode := map[string]uint32{"-r": 4, "-w": 2, "-x": 1}[op]
+		return syscall.Access(s.path(arg), mode) == nil, nil
+	case "-h", "-L":
+		info, err := os.Lstat(s.path(arg))
+		return err == nil && info.Mode()&os.ModeSymlink != 0, nil
+	}
+
+	info, err := os.Stat(s.path(arg))
+	if err != nil {
+		return false, nil
+	}
+	mode := info.Mode()
+
+	switch op {
+	case "-e", "-a":
+		return true, nil
+	case "-f":
+		return mode.IsRegular(), nil
+	case "-d":
+		return mode.IsDir(), nil
+	case "-s":
+		return info.Size() > 0, nil
+	case "-b":
+		return mode&os.ModeDevice != 0 && mode&os.ModeCharDevice == 0, nil
+	case "-c":
+		return mode&os.ModeCharDevice != 0, nil
+	case "-p":
+		return mode&os.ModeNamedPipe != 0, nil
+	case "-S":
+		return mode&os.ModeSocket != 0, nil
+	case "-g":
+		return mode&os.ModeSetgid != 0, nil
+	case "-u":
+		return mode&os.ModeSetuid != 0, nil
+	case "-k":
+		return mode&os.ModeSticky != 0, nil
+	case "-O", "-G":
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return false, nil
+		}
+		if op == "-O" {
+			return int(st.Uid) == os.Geteuid(), nil
+		}
+		return int(st.Gid) == os.Getegid(), nil
+	}
+
+	return false, fmt.Errorf("%s: unary operator expected", op)
+}
+
+// testBinary evaluates a binary test: string and integer comparisons and
+// file comparisons (-nt, -ot, -ef).
+func (s *Shell) testBinary(op, l, r string) (bool, error) {
+	switch op {
+	case "=", "==":
+		return l == r, nil
+	case "!=":
+		return l != r, nil
+	case "<":
+		return l < r, nil
+	case ">":
+		return l > r, nil
+	case "-nt", "-ot":
+		li, lerr := os.Stat(s.path(l))
+		ri, rerr := os.Stat(s.path(r))
+		if op == "-ot" {
+			li, lerr, ri, rerr = ri, rerr, li, lerr
+		}
+		if lerr != nil {
+			return false, nil
+		}
+		return rerr != nil || li.ModTime().After(ri.ModTime()), nil
+	case "-ef":
+		li, lerr := os.Stat(s.path(l))
+		ri, rerr := os.Stat(s.path(r))
+		return lerr == nil && rerr == nil && os.SameFile(li, ri), nil
+	}
+
+	a, err := testInt(l)
+	if err != nil {
+		return false, err
+	}
+	b, err := testInt(r)
+	if err != nil {
+		return false, err
+	}
+
+	switch op {
+	case "-eq":
+		return a == b, nil
+	case "-ne":
+		return a != b, nil
+	case "-lt":
+		return a < b, nil
+	case "-le":
+		return a <= b, nil
+	case "-gt":
+		return a > b, nil
+	case "-ge":
+		return a >= b, nil
+	}
+
+	return false, fmt.Errorf("%s: binary operator expected", op)
+}
+
+// testInt parses an integer operand of a comparison.
+func testInt(s string) (int64, error) {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: integer expression expected", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,421 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shirou/gopsutil/load"
+	"github.com/shirou/gopsutil/mem"
+
+	"github.com/aliskhannn/minishell/internal/term"
+)
+
+// topColumns lists the columns shown by top.
+const topColumns = "pid,user,%cpu,%mem,rss,stat,start,comm"
+
+// topHelp is shown on the status line of top.
+const topHelp = "q quit  ↑/↓ select  k kill  P cpu  M mem  N pid  </> sort column  R reverse  space refresh"
+
+// topState holds the state of the top display between refreshes.
+type topState struct {
+	opts       *psOptions
+	users      []string
+	delay      time.Duration
+	iterations int // number of refreshes, 0 for no limit
+
+	sort int  // index in opts.fields of the sort column
+	asc  bool // sort in ascending order
+
+	procs    []*procInfo
+	prevCPU  map[int32]float64 // CPU time of each process at the last refresh
+	prevTime time.Time
+
+	selected int32 // PID of the selected process
+	offset   int   // index of the first process shown
+	message  string
+}
+
+// builtinTop implements "top [-b] [-d secs] [-n count] [-o column] [-u user]",
+// a full-screen process monitor refreshed every few seconds (-d, 3 by
+// default). CPU usage is measured over the last refresh interval. Keys switch
+// the sort column and send signals to the selected process; q or Ctrl+C exit
+// and restore the terminal. In batch mode (-b, or when not attached to a
+// terminal) it prints count snapshots (one by default) instead.
+func (s *Shell) builtinTop(args []string, st *stdio) error {
+	opts := &psOptions{format: "table"}
+	_ = opts.addFields(topColumns)
+
+	t := &topState{opts: opts, delay: 3 * time.Second, sort: 2, prevCPU: map[int32]float64{}}
+	batch := false
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "-b" {
+			batch = true
+			continue
+		}
+		if len(arg) != 2 || !strings.Contains("dnou", arg[1:]) || arg[0] != '-' {
+			return fmt.Errorf("top: %s: invalid option", arg)
+		}
+		if i+1 >= len(args) {
+			return fmt.Errorf("top: %s: option requires an argument", arg)
+		}
+		i++
+		v := args[i]
+
+		switch arg {
+		case "-d":
+			secs, err := strconv.ParseFloat(v, 64)
+			if err != nil || secs <= 0 {
+				return fmt.Errorf("top: %s: invalid delay", v)
+			}
+			t.delay = time.Duration(secs * float64(time.Second))
+		case "-n":
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("top: %s: invalid count", v)
+			}
+			t.iterations = n
+		case "-o":
+			key := strings.TrimLeft(v, "+-")
+			col, ok := psColumns[strings.ToLower(key)]
+			idx := slices.IndexFunc(opts.fields, func(f psField) bool { return f.col == col })
+			if !ok || idx < 0 {
+				return fmt.Errorf("top: %s: unknown sort column", v)
+			}
+			t.sort, t.asc = idx, strings.HasPrefix(v, "+")
+		case "-u":
+			t.users = append(t.users, splitList(v)...)
+		}
+	}
+
+	in, inOK := st.in.(*os.File)
+	out, outOK := st.out.(*os.File)
+	if batch || !inOK || !outOK || !term.IsTerminal(int(in.Fd())) || !term.IsTerminal(int(out.Fd())) {
+		if t.iterations == 0 {
+			t.iterations = 1
+		}
+		return t.runBatch(st)
+	}
+
+	return t.runInteractive(in, out)
+}
+
+// refresh lists the processes again. The CPU usage of a process seen at the
+// previous refresh is computed over the interval; otherwise it is the
+// average since the process started, as in ps.
+func (t *topState) refresh() error {
+	procs, err := listProcesses()
+	if err != nil {
+		return fmt.Errorf("top: %w", err)
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(t.prevTime).Seconds()
+	cpu := make(map[int32]float64, len(procs))
+	for _, p := range procs {
+		if prev, ok := t.prevCPU[p.PID]; ok && elapsed > 0 {
+			p.CPU = 100 * (p.CPUTime - prev) / elapsed
+		}
+		cpu[p.PID] = p.CPUTime
+	}
+	t.prevCPU, t.prevTime = cpu, now
+
+	if len(t.users) > 0 {
+		procs = slices.DeleteFunc(procs, func(p *procInfo) bool { return !slices.Contains(t.users, p.User) })
+	}
+	t.procs = procs
+	t.sortProcs()
+
+	return nil
+}
+
+// sortProcs sorts the processes by the sort column.
+func (t *topState) sortProcs() {
+	t.opts.sort = []psSortKey{{col: t.opts.fields[t.sort].col, desc: !t.asc}}
+	t.opts.sortProcs(t.procs)
+}
+
+// summary returns the header lines shown above the process table.
+func (t *topState) summary() []string {
+	line := fmt.Sprintf("top - %s, %d processes", time.Now().Format("15:04:05"), len(t.procs))
+	if avg, err := load.Avg(); err == nil {
+		line += fmt.Sprintf(", load average: %.2f, %.2f, %.2f", avg.Load1, avg.Load5, avg.Load15)
+	}
+	lines := []string{line}
+
+	if vm, err := mem.VirtualMemory(); err == nil {
+		const mib = 1 << 20
+		lines = append(lines, fmt.Sprintf("MiB Mem: %.1f total, %.1f free, %.1f used, %.1f available",
+			float64(vm.Total)/mib, float64(vm.Free)/mib, float64(vm.Used)/mib, float64(vm.Available)/mib))
+	}
+
+	return append(lines, "")
+}
+
+// runBatch prints snapshots of the process table, one per refresh. Ctrl+C
+// ends it with status 130.
+func (t *topState) runBatch(st *stdio) error {
+	// Ctrl+C stops the wait between two snapshots.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT)
+	defer signal.Stop(sigCh)
+
+	for i := 0; i < t.iterations; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(t.delay):
+			case <-sigCh:
+				return errInterrupted
+			}
+			_, _ = fmt.Fprintln(st.out)
+		}
+		if err := t.refresh(); err != nil {
+			return err
+		}
+
+		lines := append(t.summary(), formatPsTable(t.opts, t.procs, nil)...)
+		for _, line := range lines {
+			_, _ = fmt.Fprintln(st.out, line)
+		}
+		if st.broken() {
+			return errBrokenPipe
+		}
+	}
+	return nil
+}
+
+// runInteractive runs the full-screen display until q or Ctrl+C is pressed
+// or the number of refreshes is reached. The terminal is put in raw mode
+// and the alternate screen is used, both restored on exit.
+func (t *topState) runInteractive(in, out *os.File) error {
+	fd := int(in.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("top: %w", err)
+	}
+	defer func() { _ = term.Restore(fd, state) }()
+
+	_, _ = out.WriteString("\x1b[?1049h\x1b[?25l")
+	defer func() { _, _ = out.WriteString("\x1b[?25h\x1b[?1049l") }()
+
+	t.message = topHelp
+	for n := 1; ; n++ {
+		if err := t.refresh(); err != nil {
+			return err
+		}
+		t.render(out)
+
+		// Handle keys until the next refresh is due.
+		deadline := time.Now().Add(t.delay)
+		for {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				break
+			}
+			ready, err := term.WaitInput(fd, int(remaining.Milliseconds())+1)
+			if err != nil {
+				return fmt.Errorf("top: %w", err)
+			}
+			if !ready {
+				break
+			}
+
+			buf := make([]byte, 16)
+			k, err := in.Read(buf)
+			if err != nil || k == 0 {
+				return nil
+			}
+
+			quit, refresh := t.key(string(buf[:k]), in, out)
+			if quit {
+				return nil
+			}
+			if refresh {
+				break
+			}
+			t.render(out)
+		}
+
+		if t.iterations > 0 && n >= t.iterations {
+			return nil
+		}
+	}
+}
+
+// key handles a key press. It reports whether top must exit and whether the
+// processes must be listed again right away.
+func (t *topState) key(k string, in, out *os.File) (quit, refresh bool) {
+	idx := slices.IndexFunc(t.procs, func(p *procInfo) bool { return p.PID == t.selected })
+
+	switch k {
+	case "q", "\x03":
+		return true, false
+	case " ":
+		return false, true
+	case "\x1b[A", "\x1b[B":
+		if k == "\x1b[A" {
+			idx--
+		} else {
+			idx++
+		}
+		if idx = max(0, min(idx, len(t.procs)-1)); idx >= 0 {
+			t.selected = t.procs[idx].PID
+		}
+	case "P", "M", "N":
+		name := map[string]string{"P": "%cpu", "M": "%mem", "N": "pid"}[k]
+		t.sort = slices.IndexFunc(t.opts.fields, func(f psField) bool { return f.col == psColumns[name] })
+		t.asc = false
+		t.sortProcs()
+	case "<", ">":
+		if k == "<" {
+			t.sort = max(0, t.sort-1)
+		} else {
+			t.sort = min(len(t.opts.fields)-1, t.sort+1)
+		}
+		t.sortProcs()
+	case "R":
+		t.asc = !t.asc
+		t.sortProcs()
+	case "k":
+		if idx >= 0 {
+			t.kill(t.procs[idx], in, out)
+		}
+		return false, true
+	case "h", "?":
+		t.message = topHelp
+	}
+
+	return false, false
+}
+
+// kill asks for a signal on the status line and sends it to the process,
+// the same way the kill builtin does.
+func (t *topState) kill(p *procInfo, in, out *os.File) {
+	prompt := fmt.Sprintf("Send signal to PID %d [TERM]: ", p.PID)
+
+	var answer []byte
+	for {
+		t.message = prompt + string(answer)
+		t.render(out)
+
+		buf := make([]byte, 16)
+		n, err := in.Read(buf)
+		if err != nil {
+			return
+		}
+		if n > 1 && buf[0] == 0x1b {
+			continue // ignore arrow and function keys
+		}
+
+		for _, c := range buf[:n] {
+			switch {
+			case c == '\r' || c == '\n':
+				t.message = t.sendSignal(p.PID, strings.TrimSpace(string(answer)))
+				return
+			case c == 0x1b || c == 0x03:
+				t.message = topHelp
+				return
+			case c == 0x7f || c == '\b':
+				if len(answer) > 0 {
+					answer = answer[:len(answer)-1]
+				}
+			case c >= ' ' && c < 0x7f:
+				answer = append(answer, c)
+			}
+		}
+	}
+}
+
+// sendSignal sends the signal named by spec (TERM if empty) to a process and
+// returns the message to show.
+func (t *topState) sendSignal(pid int32, spec string) string {
+	if spec == "" {
+		spec = "TERM"
+	}
+	sig, err := parseSignal(spec)
+	if err == nil {
+		err = killTarget(strconv.Itoa(int(pid)), sig)
+	}
+
+	switch {
+	case err != nil:
+		return "kill: " + err.Error()
+	case sig == 0:
+		return fmt.Sprintf("Process %d exists", pid)
+	default:
+		return fmt.Sprintf("Sent SIG%s to %d", signalNames[sig], pid)
+	}
+}
+
+// render draws the screen: the summary, the process table with the
+// selected process in reverse video, and the status line at the bottom.
+func (t *topState) render(out *os.File) {
+	width, height, err := term.GetSize(int(out.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+
+	// Mark the sort column in the header with an arrow.
+	opts := *t.opts
+	opts.fields = slices.Clone(t.opts.fields)
+	opts.fields[t.sort].header += map[bool]string{true: "↑", false: "↓"}[t.asc]
+
+	summary := t.summary()
+	table := formatPsTable(&opts, t.procs, nil)
+	header, rows := table[0], table[1:]
+
+	// Keep the selected process visible, scrolling if needed.
+	visible := max(1, height-len(summary)-2)
+	idx := slices.IndexFunc(t.procs, func(p *procInfo) bool { return p.PID == t.selected })
+	if idx < 0 && len(t.procs) > 0 {
+		idx = 0
+		t.selected = t.procs[0].PID
+	}
+	t.offset = max(0, min(t.offset, idx))
+	if idx >= t.offset+visible {
+		t.offset = idx - visible + 1
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	line := func(s string, attr string) {
+		s = truncate(s, width)
+		if attr != "" {
+			s = attr + s + strings.Repeat(" ", width-len([]rune(s))) + "\x1b[0m"
+		}
+		b.WriteString(s + "\x1b[K\r\n")
+	}
+
+	for _, s := range summary {
+		line(s, "")
+	}
+	line(header, "\x1b[1m")
+	for i := t.offset; i < len(rows) && i < t.offset+visible; i++ {
+		attr := ""
+		if i == idx {
+			attr = "\x1b[7m"
+		}
+		line(rows[i], attr)
+	}
+	b.WriteString("\x1b[J")
+
+	// The status line is the last line of the screen.
+	fmt.Fprintf(&b, "\x1b[%d;1H%s\x1b[K", height, truncate(t.message, width))
+
+	_, _ = out.WriteString(b.String())
+}
+
+// truncate cuts s to at most n runes.
+func truncate(s string, n int) string {
+	if rs := []rune(s); len(rs) > n {
+		return string(rs[:n])
+	}
+	return s
+}
@@ -3,14 +3,9 @@ package shell
 import "os"
 
 // IsBuiltin checks whether the given command name corresponds to a shell builtin.
-// Currently supported builtins: "cd", "pwd", "echo", "kill", "ps".
 func IsBuiltin(cmd string) bool {
-	switch cmd {
-	case "cd", "pwd", "echo", "kill", "ps":
-		return true
-	default:
-		return false
-	}
+	_, ok := builtins[cmd]
+	return ok
 }
 
 // ExpandEnv expands environment variables in the input string.
@@ -0,0 +1,163 @@
+package shell
+
+import (
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// scope holds the local variables of a function call.
+// A nil value marks a variable declared local but not set.
+type scope map[string]*string
+
+// LookupVar returns the value of a shell variable. Local variables of the
+// running functions are searched first, innermost call first (dynamic scoping).
+// Variables exported to the environment are visible as well, so
+// LookupVar("HOME") works as expected. An array yields its first element.
+func (s *Shell) LookupVar(name string) (string, bool) {
+	for i := len(s.scopes) - 1; i >= 0; i-- {
+		if v, ok := s.scopes[i][name]; ok {
+			if v == nil {
+				return "", false
+			}
+			return *v, true
+		}
+	}
+
+	if v, ok := s.vars[name]; ok {
+		return v, true
+	}
+	if a, ok := s.arrays[name]; ok && len(a) > 0 {
+		return a[0], true
+	}
+	return s.lookupEnv(name)
+}
+
+// Var returns the value of a shell variable, or "" if it is not set.
+func (s *Shell) Var(name string) string {
+	v, _ := s.LookupVar(name)
+	return v
+}
+
+// setVar assigns a shell variable. A local variable of a running function
+// takes precedence; otherwise variables that already live in the environment
+// are updated there, so child processes keep seeing them.
+func (s *Shell) setVar(name, value string) {
+	for i := len(s.scopes) - 1; i >= 0; i-- {
+		if _, ok := s.scopes[i][name]; ok {
+			s.scopes[i][name] = &value
+			return
+		}
+	}
+
+	delete(s.arrays, name)
+	if _, ok := s.lookupEnv(name); ok {
+		s.setEnv(name, value)
+		return
+	}
+	s.vars[name] = value
+}
+
+// lookupEnv returns an environment variable: the process's in the main
+// shell, the stage's own in a pipeline stage.
+func (s *Shell) lookupEnv(name string) (string, bool) {
+	if s.env != nil {
+		v, ok := s.env[name]
+		return v, ok
+	}
+	return os.LookupEnv(name)
+}
+
+// setEnv sets an environment variable, see lookupEnv.
+func (s *Shell) setEnv(name, value string) {
+	if s.env != nil {
+		s.env[name] = value
+		return
+	}
+	_ = os.Setenv(name, value)
+}
+
+// unsetEnv removes an environment variable, see lookupEnv.
+func (s *Shell) unsetEnv(name string) {
+	if s.env != nil {
+		delete(s.env, name)
+		return
+	}
+	_ = os.Unsetenv(name)
+}
+
+// environ returns the environment in "NAME=value" form, as os.Environ does.
+func (s *Shell) environ() []string {
+	if s.env == nil {
+		return os.Environ()
+	}
+	env := make([]string, 0, len(s.env))
+	for name, value := range s.env {
+		env = append(env, name+"="+value)
+	}
+	slices.Sort(env)
+	return env
+}
+
+// environMap returns a copy of the environment, for a pipeline stage.
+func (s *Shell) environMap() map[string]string {
+	if s.env != nil {
+		return maps.Clone(s.env)
+	}
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		env[name] = value
+	}
+	return env
+}
+
+// setArray assigns an indexed array, replacing a shell variable of the same
+// name. Arrays are not exported to the environment.
+func (s *Shell) setArray(name string, elems []string) {
+	delete(s.vars, name)
+	s.arrays[name] = elems
+}
+
+// assign performs a single "NAME=value" assignment, expanding the value.
+func (s *Shell) assign(word string) error {
+	name, raw, _ := strings.Cut(word, "=")
+
+	value, err := s.expandString(raw)
+	if err != nil {
+		return err
+	}
+
+	s.setVar(name, value)
+
+	return nil
+}
+
+// specialParam returns the value of a special parameter such as $?, $# or $1.
+// The boolean result is false if name is not a special parameter.
+func (s *Shell) specialParam(name string) (string, bool) {
+	switch name {
+	case "?":
+		return strconv.Itoa(s.status), true
+	case "#":
+		return strconv.Itoa(len(s.params)), true
+	case "$":
+		return strconv.Itoa(os.Getpid()), true
+	case "0":
+		return s.name, true
+	case "@", "*":
+		return strings.Join(s.params, " "), true
+	}
+
+	// Positional parameters: $1, $2, ... ${10}.
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		if n <= len(s.params) {
+			return s.params[n-1], true
+		}
+		return "", true
+	}
+
+	return "", false
+}
@@ -0,0 +1,328 @@
+package shell
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// zMaxRank is the total rank above which all ranks of the frecency database
+// are aged, so that directories that are no longer used fade out.
+const zMaxRank = 9000
+
+// zEntry is a directory in the frecency database with its rank (the number of
+// visits, aged over time) and the time of the last visit.
+type zEntry struct {
+	path string
+	rank float64
+	last time.Time
+}
+
+// frecency combines the rank of a directory with how recently it was visited.
+func (e zEntry) frecency(now time.Time) float64 {
+	switch age := now.Sub(e.last); {
+	case age < time.Hour:
+		return e.rank * 4
+	case age < 24*time.Hour:
+		return e.rank * 2
+	case age < 7*24*time.Hour:
+		return e.rank / 2
+	default:
+		return e.rank / 4
+	}
+}
+
+// zDataFile returns the path of the frecency database:
+// $XDG_DATA_HOME/minishell/z, or ~/.local/share/minishell/z.
+func (s *Shell) zDataFile() (string, error) {
+	if dir := s.Var("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "minishell", "z"), nil
+	}
+
+	home, err := s.homeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "minishell", "z"), nil
+}
+
+// loadZ reads the frecency database.
+func loadZ(path string) ([]zEntry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	// Wait for a shell that is rewriting the file.
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH); err != nil {
+		return nil, err
+	}
+	return parseZ(f)
+}
+
+// parseZ reads the entries of the frecency database. Each line has the form
+// "path|rank|unix time"; malformed lines are skipped.
+func parseZ(f *os.File) ([]zEntry, error) {
+	var entries []zEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "|")
+		if len(fields) < 3 {
+			continue
+		}
+		n := len(fields)
+		rank, err1 := strconv.ParseFloat(fields[n-2], 64)
+		last, err2 := strconv.ParseInt(fields[n-1], 10, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		// The path itself may contain "|".
+		dir := strings.Join(fields[:n-2], "|")
+		entries = append(entries, zEntry{path: dir, rank: rank, last: time.Unix(last, 0)})
+	}
+
+	return entries, scanner.Err()
+}
+
+// updateZ replaces the entries of the frecency database by the result of
+// change. The file is locked from reading to writing, like the history file,
+// so that shells updating it at the same time do not lose each other's visits.
+func updateZ(path string, change func([]zEntry) []zEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := openLocked(path, os.O_RDWR)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	entries, err := parseZ(f)
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	for _, e := range change(entries) {
+		_, _ = fmt.Fprintf(&b, "%s|%s|%d\n", e.path, strconv.FormatFloat(e.rank, 'g', -1, 64), e.last.Unix())
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.WriteAt([]byte(b.String()), 0)
+	return err
+}
+
+// recordDir adds a visit of dir to the frecency database, in interactive
+// sessions. The home directory is not recorded. Failures are ignored: they
+// must never break cd.
+func (s *Shell) recordDir(dir string) {
+	if !s.interactive {
+		return
+	}
+	if home, err := s.homeDir(); err == nil && dir == home {
+		return
+	}
+
+	path, err := s.zDataFile()
+	if err != nil {
+		return
+	}
+
+	_ = updateZ(path, func(entries []zEntry) []zEntry {
+		now := time.Now()
+		total := 1.0
+		found := false
+		for i := range entries {
+			if entries[i].path == dir {
+				entries[i].rank++
+				entries[i].last = now
+				found = true
+			}
+			total += entries[i].rank
+		}
+		if !found {
+			entries = append(entries, zEntry{path: dir, rank: 1, last: now})
+		}
+
+		// Age all entries once the total gets too high, forgetting rarely used ones.
+		if total > zMaxRank {
+			entries = slices.DeleteFunc(entries, func(e zEntry) bool { return e.rank*0.99 < 1 })
+			for i := range entries {
+				entries[i].rank *= 0.99
+			}
+		}
+		return entries
+	})
+}
+
+// builtinZ implements "z [-l] [-r | -t] [-x] [--prune] [term...]": change to
+// the most frecent directory whose path matches all terms in order. The last
+// term must match the final path component. Terms are case-insensitive unless
+// they contain upper case letters. -l lists the matching directories with
+// their scores instead, -r and -t rank by visits or recency only, -x removes
+// the current directory from the database and --prune removes directories
+// that no longer exist. Without terms, z lists all directories.
+func (s *Shell) builtinZ(args []string, st *stdio) error {
+	var list, byRank, byTime, remove, prune bool
+	var terms []string
+
+	for i, arg := range args {
+		if arg == "--" {
+			terms = append(terms, args[i+1:]...)
+			break
+		}
+		if arg == "--prune" {
+			prune = true
+			continue
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			terms = append(terms, arg)
+			continue
+		}
+		if strings.Trim(arg[1:], "lrtx") != "" {
+			return fmt.Errorf("z: %s: invalid option", arg)
+		}
+		list = list || strings.Contains(arg, "l")
+		byRank = byRank || strings.Contains(arg, "r")
+		byTime = byTime || strings.Contains(arg, "t")
+		remove = remove || strings.Contains(arg, "x")
+	}
+
+	path, err := s.zDataFile()
+	if err != nil {
+		return fmt.Errorf("z: %w", err)
+	}
+
+	if remove || prune {
+		cwd, err := s.WorkingDir()
+		if remove && err != nil {
+			return fmt.Errorf("z: cannot get current directory: %w", err)
+		}
+		err = updateZ(path, func(entries []zEntry) []zEntry {
+			return slices.DeleteFunc(entries, func(e zEntry) bool {
+				if remove && e.path == cwd {
+					return true
+				}
+				info, err := os.Stat(e.path)
+				return prune && (err != nil || !info.IsDir())
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("z: %w", err)
+		}
+		return nil
+	}
+
+	entries, err := loadZ(path)
+	if err != nil {
+		return fmt.Errorf("z: %w", err)
+	}
+
+	// A single argument naming a directory is changed to directly.
+	if len(terms) == 1 && !list {
+		if info, err := os.Stat(s.path(terms[0])); err == nil && info.IsDir() {
+			return s.zJump(terms[0])
+		}
+	}
+
+	re, err := zPattern(terms)
+	if err != nil {
+		return fmt.Errorf("z: %w", err)
+	}
+
+	now := time.Now()
+	score := func(e zEntry) float64 {
+		switch {
+		case byRank:
+			return e.rank
+		case byTime:
+			return -now.Sub(e.last).Seconds()
+		default:
+			return e.frecency(now)
+		}
+	}
+
+	var matches []zEntry
+	for _, e := range entries {
+		if !re.MatchString(e.path) {
+			continue
+		}
+		if info, err := os.Stat(e.path); err != nil || !info.IsDir() {
+			continue
+		}
+		matches = append(matches, e)
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("z: no match found")
+	}
+
+	// Lowest score first, so that the best match is printed last, next to the prompt.
+	slices.SortStableFunc(matches, func(a, b zEntry) int {
+		if c := cmp.Compare(score(a), score(b)); c != 0 {
+			return c
+		}
+		return strings.Compare(b.path, a.path)
+	})
+
+	if list || len(terms) == 0 {
+		for _, e := range matches {
+			if st.broken() {
+				return errBrokenPipe
+			}
+			_, _ = fmt.Fprintf(st.out, "%-10.1f %s\n", score(e), e.path)
+		}
+		return nil
+	}
+
+	return s.zJump(matches[len(matches)-1].path)
+}
+
+// zJump changes to dir like cd and records the visit.
+func (s *Shell) zJump(dir string) error {
+	dir, err := s.chdir(dir, false)
+	if err != nil {
+		return fmt.Errorf("z: %w", err)
+	}
+	s.recordDir(dir)
+	return nil
+}
+
+// zPattern builds the regular expression matching the paths for terms: the
+// terms in order, with the last one in the final path component.
+func zPattern(terms []string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	fold := true
+	for i, term := range terms {
+		if strings.ToLower(term) != term {
+			fold = false
+		}
+		if i > 0 {
+			b.WriteString(".*")
+		}
+		b.WriteString(regexp.QuoteMeta(term))
+	}
+	if len(terms) > 0 {
+		b.WriteString("[^/]*$")
+	}
+
+	expr := b.String()
+	if fold {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
@@ -0,0 +1,91 @@
+// Package term provides the terminal control needed by the interactive parts
+// of minishell: raw mode, window size and terminal detection.
+// It talks to the terminal driver directly through ioctl calls (Linux).
+package term
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// State is the terminal state saved by MakeRaw, to be restored by Restore.
+type State struct {
+	termios syscall.Termios
+}
+
+// ioctl performs an ioctl system call with a pointer argument.
+func ioctl(fd int, req uint, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// IsTerminal reports whether the file descriptor refers to a terminal.
+func IsTerminal(fd int) bool {
+	var t syscall.Termios
+	return ioctl(fd, syscall.TCGETS, unsafe.Pointer(&t)) == nil
+}
+
+// MakeRaw puts the terminal in raw mode: input is available byte by byte,
+// without echo, and Ctrl+C or Ctrl+Z are read as bytes instead of raising
+// signals. Output processing is turned off as well, so lines must end with
+// "\r\n". It returns the previous state.
+func MakeRaw(fd int) (*State, error) {
+	var old State
+	if err := ioctl(fd, syscall.TCGETS, unsafe.Pointer(&old.termios)); err != nil {
+		return nil, err
+	}
+
+	t := old.termios
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+
+	if err := ioctl(fd, syscall.TCSETS, unsafe.Pointer(&t)); err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+
+// Restore puts the terminal back in a state returned by MakeRaw.
+func Restore(fd int, state *State) error {
+	return ioctl(fd, syscall.TCSETS, unsafe.Pointer(&state.termios))
+}
+
+// GetSize returns the width and height of the terminal in characters.
+func GetSize(fd int) (width, height int, err error) {
+	var ws struct {
+		Row, Col, Xpixel, Ypixel uint16
+	}
+	if err := ioctl(fd, syscall.TIOCGWINSZ, unsafe.Pointer(&ws)); err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
+
+// WaitInput waits until the file descriptor has data to read or the timeout
+// (in milliseconds, -1 for none) expires. It reports whether input is ready.
+func WaitInput(fd int, timeoutMs int) (bool, error) {
+	var set syscall.FdSet
+	set.Bits[fd/64] |= 1 << (uint(fd) % 64)
+
+	var tv *syscall.Timeval
+	if timeoutMs >= 0 {
+		t := syscall.NsecToTimeval(int64(timeoutMs) * 1e6)
+		tv = &t
+	}
+
+	for {
+		n, err := syscall.Select(fd+1, &set, nil, nil, tv)
+		if err == syscall.EINTR {
+			continue
+		}
+		return n > 0, err
+	}
+}